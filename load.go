@@ -4,24 +4,51 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/tools/go/packages"
 )
 
 func (m *matcher) load(wd string, args ...string) ([]*packages.Package, error) {
+	if m.stdinName != "" {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("cannot use packages with -stdin-filename")
+		}
+		pkg, err := m.loadStdin()
+		if err != nil {
+			return nil, err
+		}
+		return []*packages.Package{pkg}, nil
+	}
 	mode := packages.NeedName | packages.NeedSyntax |
 		packages.NeedTypes | packages.NeedTypesInfo
 	if m.recursive { // need the syntax trees for the dependencies too
 		mode |= packages.NeedDeps | packages.NeedImports
 	}
+	if m.module {
+		mode |= packages.NeedModule
+	}
 	cfg := &packages.Config{
-		Mode:  mode,
-		Dir:   wd,
-		Fset:  m.fset,
-		Tests: m.tests,
+		Mode:       mode,
+		Dir:        wd,
+		Fset:       m.fset,
+		Tests:      m.tests,
+		BuildFlags: m.buildFlags(),
 	}
 	pkgs, err := packages.Load(cfg, args...)
 	if err != nil {
@@ -34,7 +61,10 @@ func (m *matcher) load(wd string, args ...string) ([]*packages.Package, error) {
 		}
 	})
 	if jointErr != "" {
-		return nil, fmt.Errorf("%s", jointErr)
+		if !m.keepGoing {
+			return nil, fmt.Errorf("%s", jointErr)
+		}
+		fmt.Fprint(m.stderr, jointErr)
 	}
 
 	// Make a sorted list of the packages, including transitive dependencies
@@ -68,5 +98,277 @@ func (m *matcher) load(wd string, args ...string) ([]*packages.Package, error) {
 	sort.Slice(pkgs, func(i, j int) bool {
 		return pkgs[i].PkgPath < pkgs[j].PkgPath
 	})
+	if m.lang != "" {
+		// dependencies go first, so that the packages importing them
+		// are type-checked against their new types
+		var ordered []*packages.Package
+		seen := make(map[*packages.Package]bool)
+		var addOrdered func(*packages.Package)
+		addOrdered = func(pkg *packages.Package) {
+			if seen[pkg] {
+				return
+			}
+			seen[pkg] = true
+			for _, imp := range pkg.Imports {
+				if byPath[imp.PkgPath] == imp {
+					addOrdered(imp)
+				}
+			}
+			ordered = append(ordered, pkg)
+		}
+		for _, pkg := range pkgs {
+			addOrdered(pkg)
+		}
+		rechecked := make(map[string]*types.Package)
+		for _, pkg := range ordered {
+			if err := m.recheck(pkg, rechecked); err != nil {
+				if !m.keepGoing {
+					return nil, err
+				}
+				fmt.Fprintln(m.stderr, err)
+			}
+			rechecked[pkg.PkgPath] = pkg.Types
+		}
+	}
 	return pkgs, nil
 }
+
+// loadStdin parses and type-checks the single file read from stdin, named
+// after -stdin-filename. Type-checking is done on a best-effort basis, as
+// the rest of its package isn't available.
+func (m *matcher) loadStdin() (*packages.Package, error) {
+	src, err := ioutil.ReadAll(m.stdin)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parser.ParseFile(m.fset, m.stdinName, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	config := &types.Config{
+		GoVersion: m.lang,
+		Importer:  importer.Default(),
+		Error:     func(error) {}, // don't stop at the first error
+	}
+	tpkg := types.NewPackage(file.Name.Name, file.Name.Name)
+	check := types.NewChecker(config, m.fset, tpkg, info)
+	_ = check.Files([]*ast.File{file})
+	return &packages.Package{
+		Name:      file.Name.Name,
+		PkgPath:   file.Name.Name,
+		Syntax:    []*ast.File{file},
+		Types:     tpkg,
+		TypesInfo: info,
+	}, nil
+}
+
+// recheck type-checks a loaded package again, using the Go language version
+// given via -lang. The loader doesn't allow configuring the type checker, so
+// its results are replaced. Imports which were already rechecked are taken
+// from rechecked, keyed by path.
+func (m *matcher) recheck(pkg *packages.Package, rechecked map[string]*types.Package) error {
+	imports := map[string]*types.Package{"unsafe": types.Unsafe}
+	for _, imp := range pkg.Types.Imports() {
+		if tpkg := rechecked[imp.Path()]; tpkg != nil {
+			imp = tpkg
+		}
+		imports[imp.Path()] = imp
+	}
+	var errs []string
+	config := &types.Config{
+		GoVersion: m.lang,
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			imp := imports[path]
+			if imp == nil {
+				return nil, fmt.Errorf("package %q not loaded", path)
+			}
+			return imp, nil
+		}),
+		Error: func(err error) {
+			errs = append(errs, err.Error())
+		},
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+	tpkg := types.NewPackage(pkg.PkgPath, pkg.Name)
+	check := types.NewChecker(config, m.fset, tpkg, info)
+	_ = check.Files(pkg.Syntax)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	pkg.Types, pkg.TypesInfo = tpkg, info
+	return nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// rxGenerated matches the standard comment marking generated Go files, as
+// described in https://golang.org/s/generatedcode.
+var rxGenerated = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether a file has the generated code comment before
+// its package clause.
+func isGenerated(file *ast.File) bool {
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if rxGenerated.MatchString(c.Text) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildConstraint returns the expression of a file's "//go:build" constraint,
+// or the empty string if it has none.
+func buildConstraint(file *ast.File) string {
+	for _, cg := range file.Comments {
+		if cg.Pos() > file.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if !constraint.IsGoBuild(c.Text) {
+				continue
+			}
+			if expr, err := constraint.Parse(c.Text); err == nil {
+				return expr.String()
+			}
+		}
+	}
+	return ""
+}
+
+// printStats prints the number of syntax nodes of each type in the packages,
+// from most to least common.
+func (m *matcher) printStats(pkgs []*packages.Package) {
+	counts := make(map[string]int)
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Syntax {
+			if m.skipGenerated && isGenerated(f) {
+				continue
+			}
+			ast.Inspect(f, func(node ast.Node) bool {
+				if node != nil {
+					counts[strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")]++
+				}
+				return true
+			})
+		}
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if ci, cj := counts[names[i]], counts[names[j]]; ci != cj {
+			return ci > cj
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Fprintf(m.out, "%6d %s\n", counts[name], name)
+	}
+}
+
+// loadEscapes runs the compiler's escape analysis on the directory of each of
+// the packages, with the same flags used to load them, and records the
+// positions it reports as escaping to the heap. This is best-effort, as the
+// escapes attribute maps those positions back to the syntax nodes.
+func (m *matcher) loadEscapes(pkgs []*packages.Package) error {
+	if m.stdinName != "" {
+		return fmt.Errorf("cannot use the escapes attribute with -stdin-filename")
+	}
+	m.heapEscapes = make(map[token.Position]bool)
+	seenDir := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if len(pkg.Syntax) == 0 || strings.HasSuffix(pkg.PkgPath, ".test") {
+			// the test main package is generated
+			continue
+		}
+		// test variants of a package share its directory
+		dir := filepath.Dir(m.fset.Position(pkg.Syntax[0].Package).Filename)
+		if seenDir[dir] {
+			continue
+		}
+		seenDir[dir] = true
+		if err := m.buildEscapes(dir); err != nil {
+			if !m.keepGoing {
+				return err
+			}
+			fmt.Fprintln(m.stderr, err)
+		}
+	}
+	return nil
+}
+
+var diagLine = regexp.MustCompile(`^(.+\.go):(\d+):(\d+): (.*)$`)
+
+// buildEscapes builds the package in dir with "-gcflags=-m", along with its
+// tests if -tests is used, and records the positions which the compiler
+// reports as escaping to the heap.
+func (m *matcher) buildEscapes(dir string) error {
+	args := []string{"build"}
+	if m.tests {
+		args = []string{"test", "-c"}
+	}
+	args = append(args, m.buildFlags()...)
+	args = append(args, "-gcflags=-m", "-o", os.DevNull, ".")
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: cannot run escape analysis: %v\n%s", dir, err,
+			bytes.TrimSpace(out))
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		sm := diagLine.FindStringSubmatch(line)
+		if sm == nil {
+			continue
+		}
+		msg := sm[4]
+		if !strings.HasSuffix(msg, " escapes to heap") &&
+			!strings.HasPrefix(msg, "moved to heap: ") {
+			continue
+		}
+		filename := sm[1]
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		line, _ := strconv.Atoi(sm[2])
+		col, _ := strconv.Atoi(sm[3])
+		m.heapEscapes[token.Position{
+			Filename: filename,
+			Line:     line,
+			Column:   col,
+		}] = true
+	}
+	return nil
+}
+
+// buildFlags returns the flags for the go tool which select the same files
+// that the packages were loaded with.
+func (m *matcher) buildFlags() []string {
+	if m.ctx == nil || len(m.ctx.BuildTags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(m.ctx.BuildTags, ",")}
+}
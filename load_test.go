@@ -6,10 +6,18 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"golang.org/x/tools/go/packages"
 )
 
 func TestLoad(t *testing.T) {
@@ -52,6 +60,10 @@ func TestLoad(t *testing.T) {
 			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(int)", "./p1"},
 			``, // different type
 		},
+		{
+			[]string{"-lang", "go1.21", "-x", "var _ = $x", "-x", "$x", "-a", "type(string)", "./p1"},
+			`p1/file1.go:3:9: "file1"`,
+		},
 		{
 			[]string{"-x", "var _ = $x", "./p1/..."},
 			`
@@ -95,6 +107,189 @@ func TestLoad(t *testing.T) {
 			[]string{"-x", "1, 2, 3, 4, 5", "exprlist.go"},
 			`exprlist.go:5:13: 1, 2, 3, 4, 5`,
 		},
+		{
+			[]string{"-x", "var _ = $x", "./gen"},
+			`
+				gen/file1.go:3:1: var _ = "file1"
+				gen/file2.go:5:1: var _ = "file2"
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-skip-generated", "./gen"},
+			`gen/file1.go:3:1: var _ = "file1"`,
+		},
+		{
+			[]string{"-f", "testdata/patterns.txt", "./prints"},
+			`
+				prints/file1.go:4:2: print(1)
+				prints/file1.go:5:2: println(2)
+			`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-only-first-match-per-file", "./prints"},
+			`prints/file1.go:4:2: print(1)`,
+		},
+		{
+			[]string{"-x", "$x", "-a", "type(string)", "-only-first-match-per-file", "./gen"},
+			`
+				gen/file1.go:3:5: _
+				gen/file2.go:5:5: _
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", `build("plan9")`, "./build"},
+			`build/file1.go:5:1: var _ = "file1"`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", `build("^gc ")`, "./build"},
+			`build/file2.go:5:1: var _ = "file2"`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", `!build(".")`, "./build"},
+			`build/file3.go:3:1: var _ = "file3"`, // no constraint
+		},
+		{
+			[]string{"-f", "testdata/patterns.txt", "-label", "./prints"},
+			`
+				prints/file1.go:4:2: [print($*_)] print(1)
+				prints/file1.go:5:2: [println($*_)] println(2)
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-distinct", "x", "./p1/..."},
+			`
+				"file1"
+				"file2"
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-distinct", "$x", "-x", "$x", "-a", "type(int)", "./p1/..."},
+			``,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-suggest", "var _ = $x + \"!\"", "./p1"},
+			`p1/file1.go:3:1: var _ = "file1" -> var _ = "file1" + "!"`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-suggest", "@suggest.txt", "./p1"},
+			`p1/file1.go:3:1: var _ = "file1" -> var _ = "file1" + "!"`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-suggest", "var _ $type($x) = $x", "./p1"},
+			`p1/file1.go:3:1: var _ = "file1" -> var _ string = "file1"`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-suggest", "var _ = $x + \"!\"", "-p", "1", "./p1"},
+			`p1/file1.go:1:1: package p1; var _ = "file1"`,
+		},
+		{
+			[]string{"-directive", "go:generate", "./directives"},
+			`
+				directives/file1.go:3:1: //go:generate stringer -type=T
+				directives/file1.go:4:1: //go:generate go run gen.go
+			`,
+		},
+		{
+			[]string{"-directive", "go:generate ^stringer", "./directives"},
+			`directives/file1.go:3:1: //go:generate stringer -type=T`,
+		},
+		{
+			[]string{"-x", "func $_() {}", "-directive", "go:noinline", "./directives"},
+			`directives/file1.go:11:1: //go:noinline`,
+		},
+		{
+			[]string{"-directive", "go:embed", "./directives"},
+			``,
+		},
+		{
+			[]string{"-x", "two", "-ast", "two/file1.go"},
+			`
+				two/file1.go:1:9:
+				     0  *ast.Ident {
+				     1  .  NamePos: ` + filepath.Join(baseDir, "two", "file1.go") + `:1:9
+				     2  .  Name: "two"
+				     3  .  Obj: nil
+				     4  }
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "./broken/..."},
+			fmt.Errorf("undefined: undefinedName"),
+		},
+		{
+			[]string{"-json", "-x", "var _ = $x", "./p1"},
+			`[{"pos":"p1/file1.go:3:1","query":"var _ = $x","match":"var _ = \"file1\"","values":{"x":"\"file1\""}}]`,
+		},
+		{
+			[]string{"-json", "-x", "var _ = $x", "-x", "$x", "-a", "type(int)", "./p1"},
+			`[]`,
+		},
+		{
+			[]string{"-json-lines", "-x", "var _ = $x", "-suggest", "var _ = $x + \"!\"", "./p1/p2"},
+			`
+				{"pos":"p1/p2/file1.go:3:1","query":"var _ = $x","match":"var _ = \"file1\"","values":{"x":"\"file1\""},"fix":"var _ = \"file1\" + \"!\""}
+				{"pos":"p1/p2/file2.go:3:1","query":"var _ = $x","match":"var _ = \"file2\"","values":{"x":"\"file2\""},"fix":"var _ = \"file2\" + \"!\""}
+			`,
+		},
+		{
+			[]string{"-json-lines", "-f", "testdata/patterns.txt", "./prints"},
+			`
+				{"pos":"prints/file1.go:4:2","query":"print($*_)","match":"print(1)"}
+				{"pos":"prints/file1.go:5:2","query":"println($*_)","match":"println(2)"}
+			`,
+		},
+		{
+			[]string{"-json", "-json-lines", "-x", "var _ = $x", "./p1"},
+			fmt.Errorf("cannot use -json and -json-lines together"),
+		},
+		{
+			[]string{"-relative-to", "p1", "-x", "var _ = $x", "./p1/p2"},
+			`
+				p2/file1.go:3:1: var _ = "file1"
+				p2/file2.go:3:1: var _ = "file2"
+			`,
+		},
+		{
+			[]string{"-relative-to", filepath.Join(baseDir, "p1", "p2"), "-x", "var _ = $x", "./p1/p2"},
+			`
+				file1.go:3:1: var _ = "file1"
+				file2.go:3:1: var _ = "file2"
+			`,
+		},
+		{
+			[]string{"-relative-to", "p1/p2", "-x", "var _ = $x", "./p1"},
+			filepath.Join(baseDir, "p1", "file1.go") + `:3:1: var _ = "file1"`,
+		},
+		{
+			[]string{"-x", "func $f() { $*_ }", "-a", "mintodos(2)", "-x", "$f", "./todos"},
+			`
+				todos/file1.go:9:6: two
+				todos/file1.go:14:6: three
+			`,
+		},
+		{
+			[]string{"-x", "func $f() { $*_ }", "-a", "!mintodos(1)", "-x", "$f", "./todos"},
+			`
+				todos/file1.go:3:6: none
+				todos/file1.go:22:6: work
+			`,
+		},
+		{
+			[]string{"-x", "func() { $*_ }", "-a", "mintodos(1)", "./todos"},
+			`todos/file1.go:17:6: func() { work(); }`,
+		},
+		{
+			[]string{"-x", "&$_", "-a", "escapes", "./escapes"},
+			`escapes/file1.go:5:24: &T{n: 1}`,
+		},
+		{
+			[]string{"-x", "$x := $_", "-x", "$x", "-a", "escapes", "./escapes"},
+			`escapes/file1.go:13:2: x`,
+		},
+		{
+			[]string{"-tests", "-x", "&$_", "-a", "escapes", "./escapes"},
+			"escapes/file1.go:5:24: &T{n: 1}\nescapes/file1_test.go:8:9: &T{n: 4}",
+		},
 	}
 	for i, tc := range tests {
 		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
@@ -127,3 +322,287 @@ func TestLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadRecheck(t *testing.T) {
+	m := matcher{fset: token.NewFileSet()}
+	load := func(path, src string, imports ...*types.Package) *packages.Package {
+		file, err := parser.ParseFile(m.fset, path+".go", src, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := &types.Config{Importer: importerFunc(func(path string) (*types.Package, error) {
+			for _, imp := range imports {
+				if imp.Path() == path {
+					return imp, nil
+				}
+			}
+			return nil, fmt.Errorf("package %q not loaded", path)
+		})}
+		tpkg, err := config.Check(path, m.fset, []*ast.File{file}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &packages.Package{
+			Name:    file.Name.Name,
+			PkgPath: path,
+			Syntax:  []*ast.File{file},
+			Types:   tpkg,
+		}
+	}
+	q := load("q", "package q\n\nfunc Identity[T any](x T) T { return x }\n")
+	p := load("p", "package p\n\nimport \"q\"\n\nvar _ = q.Identity(3)\n", q.Types)
+
+	m.lang = "go1.21.0"
+	rechecked := make(map[string]*types.Package)
+	for _, pkg := range []*packages.Package{q, p} {
+		if err := m.recheck(pkg, rechecked); err != nil {
+			t.Fatalf("didn't want error, but got %q", err)
+		}
+		rechecked[pkg.PkgPath] = pkg.Types
+	}
+	if p.Types.Imports()[0] != q.Types {
+		t.Fatalf("wanted p to import the rechecked q")
+	}
+
+	m.lang = "go1.17"
+	err := m.recheck(q, nil)
+	if want := "requires go1.18"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("wanted error %q, got %v", want, err)
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	baseDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("GOGREP_RULES")
+	os.Setenv("GOGREP_RULES", filepath.Join("testdata", "patterns.txt"))
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"./prints"},
+			`
+				prints/file1.go:4:2: print(1)
+				prints/file1.go:5:2: println(2)
+			`,
+		},
+		{
+			[]string{"-x", "println($*_)", "./prints"},
+			`prints/file1.go:5:2: println(2)`,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			m := matcher{ctx: &build.Default}
+			var buf bytes.Buffer
+			m.out = &buf
+			if err := m.fromArgs(baseDir, tc.args); err != nil {
+				t.Fatalf("didn't want error, but got %q", err)
+			}
+			want := strings.TrimSpace(strings.Replace(tc.want, "\t", "", -1))
+			got := strings.TrimSpace(buf.String())
+			want = filepath.FromSlash(want)
+			if want != got {
+				t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestLoadKeepGoing(t *testing.T) {
+	baseDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-keep-going", "-x", "var _ = $x", "./broken/..."},
+			`
+				broken/bad/file1.go:3:1: var _ = "bad"
+				broken/good/file1.go:3:1: var _ = "good"
+			`,
+		},
+		{
+			[]string{"-keep-going", "-x", "var _ = $x", "-x", "$x", "-a", "type(string)", "./broken/..."},
+			`
+				broken/bad/file1.go:3:9: "bad"
+				broken/good/file1.go:3:9: "good"
+			`,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			m := matcher{ctx: &build.Default}
+			var buf, errBuf bytes.Buffer
+			m.out = &buf
+			m.stderr = &errBuf
+			if err := m.fromArgs(baseDir, tc.args); err != nil {
+				t.Fatalf("didn't want error, but got %q", err)
+			}
+			want := strings.TrimSpace(strings.Replace(tc.want, "\t", "", -1))
+			got := strings.TrimSpace(buf.String())
+			want = filepath.FromSlash(want)
+			if want != got {
+				t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+			}
+			if !strings.Contains(errBuf.String(), "undefined: undefinedName") {
+				t.Fatalf("wanted a warning, got %q", errBuf.String())
+			}
+		})
+	}
+}
+
+func TestLoadOutput(t *testing.T) {
+	baseDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "gogrep-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.txt")
+
+	m := matcher{ctx: &build.Default}
+	var buf bytes.Buffer
+	m.out = &buf
+	args := []string{"-x", "var _ = $x", "-o", path, "./p1"}
+	if err := m.fromArgs(baseDir, args); err != nil {
+		t.Fatalf("didn't want error, but got %q", err)
+	}
+	if got := buf.String(); got != "" {
+		t.Fatalf("got non-empty output:\n%s", got)
+	}
+	gotBs, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.FromSlash(`p1/file1.go:3:1: var _ = "file1"` + "\n")
+	if got := string(gotBs); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestLoadWorkspace(t *testing.T) {
+	baseDir, err := filepath.Abs(filepath.Join("testdata", "work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// workspace mode doesn't allow -mod=mod
+	defer os.Setenv("GOFLAGS", os.Getenv("GOFLAGS"))
+	os.Setenv("GOFLAGS", "")
+
+	m := matcher{ctx: &build.Default}
+	var buf bytes.Buffer
+	m.out = &buf
+	args := []string{"-x", "var _ = $x", "-module", "example.com/a", "example.com/b"}
+	if err := m.fromArgs(baseDir, args); err != nil {
+		t.Fatalf("didn't want error, but got %q", err)
+	}
+	want := filepath.FromSlash(strings.TrimSpace(`
+a/a.go:3:1: [example.com/a] var _ = "a"
+b/b.go:3:1: [example.com/b] var _ = "b"
+`))
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestLoadStdin(t *testing.T) {
+	m := matcher{ctx: &build.Default}
+	var buf bytes.Buffer
+	m.out = &buf
+	m.stdin = strings.NewReader("package p\n\nvar _ = \"stdin\"\nvar _ = 3\n")
+	args := []string{"-x", "var _ = $x", "-x", "$x", "-a", "type(string)", "-stdin-filename", "foo/bar.go"}
+	if err := m.fromArgs(".", args); err != nil {
+		t.Fatalf("didn't want error, but got %q", err)
+	}
+	want := `foo/bar.go:3:9: "stdin"`
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+
+	args = []string{"-x", "foo", "-stdin-filename", "foo.go", "./p1"}
+	m.stdin = strings.NewReader("package p\n")
+	if err := m.fromArgs(".", args); err == nil {
+		t.Fatalf("wanted error with both stdin and packages")
+	}
+}
+
+func TestLoadPatternStdin(t *testing.T) {
+	baseDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := matcher{ctx: &build.Default}
+	var buf bytes.Buffer
+	m.out = &buf
+	m.stdin = strings.NewReader("\nvar _ =\n\t$x\n")
+	args := []string{"-pattern-stdin", "-x", "$x", "./p1"}
+	if err := m.fromArgs(baseDir, args); err != nil {
+		t.Fatalf("didn't want error, but got %q", err)
+	}
+	want := filepath.FromSlash(`p1/file1.go:3:9: "file1"`)
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+
+	args = []string{"-pattern-stdin", "-stdin-filename", "foo.go"}
+	m.stdin = strings.NewReader("foo")
+	if err := m.fromArgs(".", args); err == nil {
+		t.Fatalf("wanted error with both -pattern-stdin and -stdin-filename")
+	}
+}
+
+func TestLoadStats(t *testing.T) {
+	baseDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := matcher{ctx: &build.Default}
+	var buf bytes.Buffer
+	m.out = &buf
+	if err := m.fromArgs(baseDir, []string{"-stats", "./p1/p2"}); err != nil {
+		t.Fatalf("didn't want error, but got %q", err)
+	}
+	want := `     4 Ident
+     2 BasicLit
+     2 File
+     2 GenDecl
+     2 ValueSpec
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestLoadSuggestNested(t *testing.T) {
+	baseDir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := matcher{ctx: &build.Default}
+	var buf, errBuf bytes.Buffer
+	m.out = &buf
+	m.stderr = &errBuf
+	args := []string{"-json-lines", "-x", "f($x)", "-suggest", "g($x)", "./nested"}
+	if err := m.fromArgs(baseDir, args); err != nil {
+		t.Fatalf("didn't want error, but got %q", err)
+	}
+	want := filepath.FromSlash(`{"pos":"nested/file1.go:3:28","query":"f($x)","match":"f(f(1))","values":{"x":"f(1)"},"fix":"g(f(1))"}
+{"pos":"nested/file1.go:3:30","query":"f($x)","match":"f(1)","values":{"x":"1"}}`)
+	if got := strings.TrimSpace(buf.String()); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+	wantErr := filepath.FromSlash("nested/file1.go:3:30: skipping suggestion within another one")
+	if !strings.Contains(errBuf.String(), wantErr) {
+		t.Fatalf("wanted a warning, got %q", errBuf.String())
+	}
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/ast"
@@ -12,8 +13,11 @@ import (
 	"go/printer"
 	"go/token"
 	"go/types"
+	"go/version"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -26,20 +30,85 @@ gogrep performs a query on the given Go packages.
 
   -r      search dependencies recursively too
   -tests  search test files too (and direct test deps, with -r)
+  -skip-generated
+          skip files marked as generated code
+  -ast    print the syntax tree of each match, for debugging
+  -label  prefix each match with the -f pattern that found it
+  -token-regex
+          use -x patterns as regexes over each top-level declaration
+  -lang version
+          Go language version to type-check with, like go1.21
+  -distinct name
+          print the distinct values of $name across all matches
+  -o file
+          write the results to a file instead of standard output
+  -relative-to dir
+          print positions relative to a directory, such as the module root,
+          instead of the current directory
+  -module
+          prefix each match with its module path, e.g. within a go.work
+  -stdin-filename name
+          read the source of a file named name from stdin, instead of loading
+          packages
+  -tabwidth n
+          with -w, indent with n spaces instead of tabs
+  -stats  print how many syntax nodes of each type there are, such as CallExpr,
+          instead of running any commands
+  -pattern-stdin
+          read a pattern from stdin, which may span many lines, and use it as
+          the first -x command
+  -keep-going
+          print package load and type errors as warnings, and search whatever
+          could be loaded anyway
+  -only-first-match-per-file
+          only print the earliest match in each file, to show whether a file
+          contains any at all
+  -json   print the matches as a JSON array of objects, with their position,
+          query, source, and the source of each wildcard value
+  -json-lines
+          like -json, but print one object per line, for streaming
 
 A command is one of the following:
 
   -x pattern    find all nodes matching a pattern
+  -f file       find all nodes matching any pattern in a file, one per line
+  -directive d  find all directive comments like "go:generate", optionally
+                followed by a regexp their arguments must match
   -g pattern    discard nodes not matching a pattern
+  -bind "name pattern"
+                name the first node within each match that matches a pattern, as
+                $name, and discard matches without one
   -v pattern    discard nodes matching a pattern
   -a attribute  discard nodes without an attribute
   -s pattern    substitute with a given syntax tree
+  -wrap pattern
+                substitute with a pattern where $_ stands for the match
+  -suggest pattern
+                print a substitution next to each match, without applying it
+                (the patterns given to -s, -wrap and -suggest can be read from a
+                file, as in "-s @file", and can use $type($x) to refer to the
+                type of $x; they skip matches within other matches)
   -p number     navigate up a number of node parents
+  -repeated number
+                discard nodes whose source appears fewer times than a number,
+                within the same package
+  -stmt         navigate up to the enclosing statement
+  -outermost    discard nodes contained within other matching nodes
+  -innermost    discard nodes containing other matching nodes
+  -positional   rewrite keyed struct literals as positional ones
+  -keyed        rewrite positional struct literals as keyed ones
+  -wrapverb     rewrite fmt.Errorf calls to wrap the errors they format, if none
+                are wrapped yet
   -w            write the entire source code back
 
 A pattern is a piece of Go code which may include dollar expressions. It can be
-a number of statements, a number of expressions, a declaration, or an entire
-file.
+a number of statements, a number of expressions, a declaration, an import,
+a number of switch or select clauses, or an entire file.
+
+A pattern may start with a hint, one of "file:", "decl:", "stmt:", "expr:" or
+"type:", to force it to be parsed as that kind of node. Example:
+
+       -x 'stmt: f()' # calls to f as statements, not within expressions
 
 A dollar expression consist of '$' and a name. Dollar expressions with the same
 name within a query always match the same node, excluding "_". Example:
@@ -50,15 +119,33 @@ If '*' is before the name, it will match any number of nodes. Example:
 
        -x 'fmt.Fprintf(os.Stdout, $*_)' # all Fprintfs on stdout
 
+Such a name used again without '*' will match each of its nodes. Example:
+
+       -x 'f($*args)' -x '$args' # each of the arguments to f
+
+If '?' is before the name, it will match zero or one nodes. Example:
+
+       -x '$x[$lo:$?hi]' # all slice expressions, with or without high bound
+
+If '=' directly follows a dollar expression after an operand, it will match any
+compound assignment operator. Example:
+
+       -x '$x $op= $y' # all assignments like x += y or x <<= y
+
 By default, the resulting nodes will be printed one per line to standard output.
 To update the input files, use -w.
+
+If no commands are given, the patterns are read from the file named by
+$GOGREP_RULES, or from a .gogrep file in the current directory, as if with -f.
 `)
 }
 
 func main() {
 	m := matcher{
-		out: os.Stdout,
-		ctx: &build.Default,
+		out:    os.Stdout,
+		stdin:  os.Stdin,
+		stderr: os.Stderr,
+		ctx:    &build.Default,
 	}
 	err := m.fromArgs(".", os.Args[1:])
 	if err != nil {
@@ -68,16 +155,67 @@ func main() {
 }
 
 type matcher struct {
-	out io.Writer
-	ctx *build.Context
+	out    io.Writer
+	stdin  io.Reader
+	stderr io.Writer
+	ctx    *build.Context
 
 	fset *token.FileSet
 
+	// directory to resolve relative paths against, like those of
+	// patterns read from files
+	wd string
+
 	parents map[ast.Node]ast.Node
 
 	recursive, tests bool
+	skipGenerated    bool
+	astDump          bool
+	label            bool
+	tokenRegex       bool
 	aggressive       bool
 
+	// Go language version to type-check with, like "go1.21"
+	lang string
+
+	// name of the wildcard whose distinct values are printed instead
+	// of the matches, if any
+	distinct string
+
+	// file to write the results to instead of out, if any
+	outPath string
+
+	// whether to prefix each match with its module path
+	module bool
+
+	// directory to make the match positions relative to, instead of the
+	// working directory
+	relativeTo string
+
+	// name of the file read from stdin, if any
+	stdinName string
+
+	// number of spaces to indent with when writing files, or zero to
+	// indent with tabs
+	tabWidth int
+
+	// whether to print how many nodes of each type there are, instead
+	// of running any commands
+	stats bool
+
+	// whether to read a pattern from stdin, used as the first -x
+	patternStdin bool
+
+	// whether to print package errors as warnings and keep going
+	keepGoing bool
+
+	// whether to only print the first match in each file
+	firstPerFile bool
+
+	// whether to print the matches as a JSON array, or as one JSON
+	// object per line
+	jsonArray, jsonLines bool
+
 	// information about variables (wildcards), by id (which is an
 	// integer starting at 0)
 	vars []varInfo
@@ -87,13 +225,32 @@ type matcher struct {
 	values map[string]ast.Node
 	scope  *types.Scope
 
+	// whether values is shared with the parent submatch, and must be
+	// copied before it is modified
+	valuesShared bool
+
 	*types.Info
 	stdImporter types.Importer
+
+	// the package being searched, as opposed to its imports
+	pkg *types.Package
+
+	// errors found while writing files back to disk, in order
+	writeErrs []error
+
+	// positions which escape to the heap; only filled for the escapes
+	// attribute, as it needs a build
+	heapEscapes map[token.Position]bool
 }
 
 type varInfo struct {
-	name string
-	any  bool
+	name     string
+	any      bool
+	optional bool
+
+	// whether it stands for a compound assignment operator, as in
+	// "$x $op= $y"
+	assignOp bool
 }
 
 func (m *matcher) info(id int) varInfo {
@@ -135,46 +292,211 @@ func (o *boolCmdFlag) Set(val string) error {
 }
 func (o *boolCmdFlag) IsBoolFlag() bool { return true }
 
-func (m *matcher) fromArgs(wd string, args []string) error {
+func (m *matcher) fromArgs(wd string, args []string) (err error) {
 	m.fset = token.NewFileSet()
+	m.wd = wd
 	cmds, args, err := m.parseCmds(args)
 	if err != nil {
 		return err
 	}
+	if m.outPath != "" {
+		f, err := os.Create(m.outPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		m.out = f
+	}
 	pkgs, err := m.load(wd, args...)
 	if err != nil {
 		return err
 	}
-	var all []ast.Node
+	if usesAttr(cmds, typProperty("escapes")) {
+		if err := m.loadEscapes(pkgs); err != nil {
+			return err
+		}
+	}
+	if m.stats {
+		m.printStats(pkgs)
+		return nil
+	}
+	var all []submatch
+	// module paths by filename, for -module
+	modules := make(map[string]string)
 	for _, pkg := range pkgs {
-		m.Info = pkg.TypesInfo
-		nodes := make([]ast.Node, len(pkg.Syntax))
-		for i, f := range pkg.Syntax {
-			nodes[i] = f
+		m.Info, m.pkg = pkg.TypesInfo, pkg.Types
+		nodes := make([]ast.Node, 0, len(pkg.Syntax))
+		for _, f := range pkg.Syntax {
+			if m.skipGenerated && isGenerated(f) {
+				continue
+			}
+			if m.module && pkg.Module != nil {
+				modules[m.fset.Position(f.Pos()).Filename] = pkg.Module.Path
+			}
+			nodes = append(nodes, f)
+		}
+		all = append(all, m.submatchesOf(cmds, nodes)...)
+	}
+	if len(m.writeErrs) > 0 {
+		errs := make([]string, len(m.writeErrs))
+		for i, err := range m.writeErrs {
+			errs[i] = err.Error()
+		}
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	if m.firstPerFile {
+		all = m.firstInEachFile(all)
+	}
+	if m.distinct != "" {
+		m.printDistinct(all)
+		return nil
+	}
+	base := wd
+	if m.relativeTo != "" {
+		base = m.relativeTo
+		if !filepath.IsAbs(base) {
+			base = filepath.Join(wd, base)
 		}
-		all = append(all, m.matches(cmds, nodes)...)
+		base = filepath.Clean(base)
 	}
-	for _, n := range all {
+	enc := json.NewEncoder(m.out)
+	jsonAll := []jsonMatch{} // not null if empty
+	for _, sub := range all {
+		n := sub.node
 		fpos := m.fset.Position(n.Pos())
-		if strings.HasPrefix(fpos.Filename, wd) {
-			fpos.Filename = fpos.Filename[len(wd)+1:]
+		prefix := ""
+		mod := modules[fpos.Filename]
+		if mod != "" {
+			prefix += "[" + mod + "] "
+		}
+		if m.label && sub.label != "" {
+			prefix += "[" + sub.label + "] "
+		}
+		if strings.HasPrefix(fpos.Filename, base+string(filepath.Separator)) {
+			fpos.Filename = fpos.Filename[len(base)+1:]
+		}
+		if m.jsonArray || m.jsonLines {
+			jm := m.jsonMatch(sub, cmds)
+			jm.Pos = fpos.String()
+			jm.Module = mod
+			if m.jsonArray {
+				jsonAll = append(jsonAll, jm)
+			} else if err := enc.Encode(jm); err != nil {
+				return err
+			}
+			continue
+		}
+		if m.astDump {
+			fmt.Fprintf(m.out, "%v:\n", fpos)
+			ast.Fprint(m.out, m.fset, n, nil)
+			continue
+		}
+		if sub.fix != nil {
+			fmt.Fprintf(m.out, "%v: %s%s -> %s\n", fpos, prefix,
+				singleLinePrint(n), singleLinePrint(sub.fix))
+			continue
 		}
-		fmt.Fprintf(m.out, "%v: %s\n", fpos, singleLinePrint(n))
+		fmt.Fprintf(m.out, "%v: %s%s\n", fpos, prefix, singleLinePrint(n))
+	}
+	if m.jsonArray {
+		return enc.Encode(jsonAll)
 	}
 	return nil
 }
 
+// jsonMatch is a match as printed by -json and -json-lines.
+type jsonMatch struct {
+	Pos    string `json:"pos"`
+	Module string `json:"module,omitempty"`
+
+	// the -f pattern that found the match, or the first command
+	Query string `json:"query"`
+
+	Match  string            `json:"match"`
+	Values map[string]string `json:"values,omitempty"`
+	Fix    string            `json:"fix,omitempty"`
+}
+
+func (m *matcher) jsonMatch(sub submatch, cmds []exprCmd) jsonMatch {
+	jm := jsonMatch{Query: sub.label}
+	if jm.Query == "" {
+		jm.Query = cmds[0].src
+	}
+	jm.Match = singleLinePrint(sub.node)
+	if sub.fix != nil {
+		jm.Fix = singleLinePrint(sub.fix)
+	}
+	for name, node := range sub.values {
+		if jm.Values == nil {
+			jm.Values = make(map[string]string)
+		}
+		jm.Values[name] = singleLinePrint(node)
+	}
+	return jm
+}
+
+// printDistinct prints each distinct value of the -distinct wildcard once, in
+// the order they were first found.
+func (m *matcher) printDistinct(all []submatch) {
+	name := strings.TrimPrefix(m.distinct, "$")
+	seen := make(map[string]bool)
+	for _, sub := range all {
+		node := sub.values[name]
+		if node == nil {
+			continue
+		}
+		str := singleLinePrint(node)
+		if !seen[str] {
+			fmt.Fprintln(m.out, str)
+			seen[str] = true
+		}
+	}
+}
+
 func (m *matcher) parseCmds(args []string) ([]exprCmd, []string, error) {
 	flagSet := flag.NewFlagSet("gogrep", flag.ExitOnError)
 	flagSet.Usage = usage
 	flagSet.BoolVar(&m.recursive, "r", false, "search dependencies recursively too")
 	flagSet.BoolVar(&m.tests, "tests", false, "search test files too (and direct test deps, with -r)")
+	flagSet.BoolVar(&m.skipGenerated, "skip-generated", false, "skip files marked as generated code")
+	flagSet.BoolVar(&m.astDump, "ast", false, "print the syntax tree of each match")
+	flagSet.BoolVar(&m.label, "label", false, "prefix each match with the -f pattern that found it")
+	flagSet.BoolVar(&m.tokenRegex, "token-regex", false, "use -x patterns as regexes over each top-level declaration")
+	flagSet.StringVar(&m.lang, "lang", "", "Go language version to type-check with")
+	flagSet.StringVar(&m.distinct, "distinct", "", "print the distinct values of a wildcard")
+	flagSet.StringVar(&m.outPath, "o", "", "write the results to a file")
+	flagSet.StringVar(&m.relativeTo, "relative-to", "", "print positions relative to a directory")
+	flagSet.BoolVar(&m.module, "module", false, "prefix each match with its module path")
+	flagSet.StringVar(&m.stdinName, "stdin-filename", "", "read a file with the given name from stdin")
+	flagSet.IntVar(&m.tabWidth, "tabwidth", 0, "with -w, indent with this many spaces")
+	flagSet.BoolVar(&m.stats, "stats", false, "print the number of nodes of each type")
+	flagSet.BoolVar(&m.patternStdin, "pattern-stdin", false, "read a pattern from stdin, used as the first -x")
+	flagSet.BoolVar(&m.keepGoing, "keep-going", false, "print package errors as warnings and keep going")
+	flagSet.BoolVar(&m.firstPerFile, "only-first-match-per-file", false, "only print the first match in each file")
+	flagSet.BoolVar(&m.jsonArray, "json", false, "print the matches as a JSON array")
+	flagSet.BoolVar(&m.jsonLines, "json-lines", false, "print each match as a JSON object in a line")
 
 	var cmds []exprCmd
 	flagSet.Var(&strCmdFlag{
 		name: "x",
 		cmds: &cmds,
 	}, "x", "")
+	flagSet.Var(&strCmdFlag{
+		name: "f",
+		cmds: &cmds,
+	}, "f", "")
+	flagSet.Var(&strCmdFlag{
+		name: "directive",
+		cmds: &cmds,
+	}, "directive", "")
+	flagSet.Var(&strCmdFlag{
+		name: "bind",
+		cmds: &cmds,
+	}, "bind", "")
 	flagSet.Var(&strCmdFlag{
 		name: "g",
 		cmds: &cmds,
@@ -191,10 +513,46 @@ func (m *matcher) parseCmds(args []string) ([]exprCmd, []string, error) {
 		name: "s",
 		cmds: &cmds,
 	}, "s", "")
+	flagSet.Var(&strCmdFlag{
+		name: "wrap",
+		cmds: &cmds,
+	}, "wrap", "")
+	flagSet.Var(&strCmdFlag{
+		name: "suggest",
+		cmds: &cmds,
+	}, "suggest", "")
 	flagSet.Var(&strCmdFlag{
 		name: "p",
 		cmds: &cmds,
 	}, "p", "")
+	flagSet.Var(&strCmdFlag{
+		name: "repeated",
+		cmds: &cmds,
+	}, "repeated", "")
+	flagSet.Var(&boolCmdFlag{
+		name: "stmt",
+		cmds: &cmds,
+	}, "stmt", "")
+	flagSet.Var(&boolCmdFlag{
+		name: "outermost",
+		cmds: &cmds,
+	}, "outermost", "")
+	flagSet.Var(&boolCmdFlag{
+		name: "innermost",
+		cmds: &cmds,
+	}, "innermost", "")
+	flagSet.Var(&boolCmdFlag{
+		name: "positional",
+		cmds: &cmds,
+	}, "positional", "")
+	flagSet.Var(&boolCmdFlag{
+		name: "keyed",
+		cmds: &cmds,
+	}, "keyed", "")
+	flagSet.Var(&boolCmdFlag{
+		name: "wrapverb",
+		cmds: &cmds,
+	}, "wrapverb", "")
 	flagSet.Var(&boolCmdFlag{
 		name: "w",
 		cmds: &cmds,
@@ -202,19 +560,75 @@ func (m *matcher) parseCmds(args []string) ([]exprCmd, []string, error) {
 	flagSet.Parse(args)
 	paths := flagSet.Args()
 
-	if len(cmds) < 1 {
+	if m.patternStdin {
+		if m.stdinName != "" {
+			return nil, nil, fmt.Errorf("cannot use -pattern-stdin with -stdin-filename")
+		}
+		src, err := ioutil.ReadAll(m.stdin)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd := exprCmd{name: "x", src: strings.TrimSpace(string(src))}
+		cmds = append([]exprCmd{cmd}, cmds...)
+	}
+	if len(cmds) == 0 && !m.stats {
+		// fall back to the default set of patterns, if any
+		if path := rulesFile(); path != "" {
+			cmds = append(cmds, exprCmd{name: "f", src: path})
+		}
+	}
+	if len(cmds) < 1 && !m.stats {
 		return nil, nil, fmt.Errorf("need at least one command")
 	}
+	if len(cmds) > 0 && m.stats {
+		return nil, nil, fmt.Errorf("cannot use -stats with commands")
+	}
+	if m.lang != "" && !version.IsValid(m.lang) {
+		return nil, nil, fmt.Errorf("invalid Go version: %q", m.lang)
+	}
+	if m.tabWidth < 0 {
+		return nil, nil, fmt.Errorf("invalid tab width: %d", m.tabWidth)
+	}
+	if m.jsonArray && m.jsonLines {
+		return nil, nil, fmt.Errorf("cannot use -json and -json-lines together")
+	}
 	for i, cmd := range cmds {
+		if cmd.name == "x" && m.tokenRegex {
+			rx, err := regexp.Compile(cmd.src)
+			if err != nil {
+				return nil, nil, err
+			}
+			cmds[i].value = rx
+			continue
+		}
 		switch cmd.name {
-		case "w":
+		case "w", "stmt", "outermost", "innermost", "positional", "keyed",
+			"wrapverb":
 			continue // no expr
-		case "p":
+		case "p", "repeated":
 			n, err := strconv.Atoi(cmd.src)
 			if err != nil {
 				return nil, nil, err
 			}
 			cmds[i].value = n
+		case "f":
+			subCmds, err := m.parseFile(cmd.src)
+			if err != nil {
+				return nil, nil, err
+			}
+			cmds[i].value = subCmds
+		case "directive":
+			dir, err := parseDirective(cmd.src)
+			if err != nil {
+				return nil, nil, err
+			}
+			cmds[i].value = dir
+		case "bind":
+			b, err := m.parseBinding(cmd.src)
+			if err != nil {
+				return nil, nil, err
+			}
+			cmds[i].value = b
 		case "a":
 			m, err := m.parseAttrs(cmd.src)
 			if err != nil {
@@ -222,7 +636,26 @@ func (m *matcher) parseCmds(args []string) ([]exprCmd, []string, error) {
 			}
 			cmds[i].value = m
 		default:
-			node, err := m.parseExpr(cmd.src)
+			isRepl := cmd.name == "s" || cmd.name == "wrap" || cmd.name == "suggest"
+			if isRepl && strings.HasPrefix(cmd.src, "@") {
+				// a replacement read from a file, as it may
+				// span many lines
+				path := cmd.src[1:]
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(m.wd, path)
+				}
+				src, err := ioutil.ReadFile(path)
+				if err != nil {
+					return nil, nil, err
+				}
+				cmds[i].src = strings.TrimSpace(string(src))
+			}
+			src := cmds[i].src
+			if isRepl {
+				// the types are only known once there are values
+				src = typeMacro.ReplaceAllString(src, "$$_")
+			}
+			node, err := m.parseExpr(src)
 			if err != nil {
 				return nil, nil, err
 			}
@@ -232,6 +665,41 @@ func (m *matcher) parseCmds(args []string) ([]exprCmd, []string, error) {
 	return cmds, paths, nil
 }
 
+// parseFile parses the patterns in a file, one per line, as -x commands.
+// Empty lines and lines starting with "//" are skipped.
+func (m *matcher) parseFile(path string) ([]exprCmd, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cmds []exprCmd
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		node, err := m.parseExpr(line)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, exprCmd{name: "x", src: line, value: node})
+	}
+	return cmds, nil
+}
+
+// rulesFile returns the file with the patterns to use when none are given as
+// commands. It is either set via $GOGREP_RULES, or a .gogrep file in the
+// current directory.
+func rulesFile() string {
+	if path := os.Getenv("GOGREP_RULES"); path != "" {
+		return path
+	}
+	if _, err := os.Stat(".gogrep"); err == nil {
+		return ".gogrep"
+	}
+	return ""
+}
+
 type bufferJoinLines struct {
 	bytes.Buffer
 	last string
@@ -322,6 +790,26 @@ func printNode(w io.Writer, fset *token.FileSet, node ast.Node) {
 			fmt.Fprintf(w, "; ")
 			printNode(w, fset, n)
 		}
+	case fieldList:
+		// not supported by go/printer either, e.g. "a, b int, c string"
+		for i, field := range x {
+			if i > 0 {
+				fmt.Fprintf(w, ", ")
+			}
+			for j, name := range field.Names {
+				if j > 0 {
+					fmt.Fprintf(w, ", ")
+				}
+				fmt.Fprint(w, name.Name)
+			}
+			if len(field.Names) > 0 {
+				fmt.Fprintf(w, " ")
+			}
+			printNode(w, fset, field.Type)
+		}
+	case *ast.Comment:
+		// not supported by go/printer on its own
+		fmt.Fprint(w, x.Text)
 	default:
 		err := printer.Fprint(w, fset, node)
 		if err != nil && strings.Contains(err.Error(), "go/printer: unsupported node type") {
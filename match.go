@@ -6,27 +6,61 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/importer"
 	"go/token"
 	"go/types"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 func (m *matcher) matches(cmds []exprCmd, nodes []ast.Node) []ast.Node {
+	final := m.submatchesOf(cmds, nodes)
+	finalNodes := make([]ast.Node, len(final))
+	for i := range finalNodes {
+		finalNodes[i] = final[i].node
+	}
+	return finalNodes
+}
+
+func (m *matcher) submatchesOf(cmds []exprCmd, nodes []ast.Node) []submatch {
 	m.parents = make(map[ast.Node]ast.Node)
-	m.fillParents(nodes...)
+	if needsParents(cmds) {
+		m.fillParents(nodes...)
+	}
 	initial := make([]submatch, len(nodes))
 	for i, node := range nodes {
 		initial[i].node = node
 		initial[i].values = make(map[string]ast.Node)
 	}
-	final := m.submatches(cmds, initial)
-	finalNodes := make([]ast.Node, len(final))
-	for i := range finalNodes {
-		finalNodes[i] = final[i].node
+	return m.submatches(cmds, initial)
+}
+
+// needsParents reports whether any of the commands may navigate or modify the
+// syntax tree, and thus need to know the parent of each node. Building that
+// map is expensive on large inputs, so the commands which only match skip it.
+func needsParents(cmds []exprCmd) bool {
+	for _, cmd := range cmds {
+		switch cmd.name {
+		case "x", "g", "v":
+		default:
+			return true
+		}
 	}
-	return finalNodes
+	return false
+}
+
+// usesAttr reports whether any of the commands discards nodes based on attr.
+func usesAttr(cmds []exprCmd, attr interface{}) bool {
+	for _, cmd := range cmds {
+		if cmd.name == "a" && cmd.value.(attribute).under == attr {
+			return true
+		}
+	}
+	return false
 }
 
 func (m *matcher) fillParents(nodes ...ast.Node) {
@@ -49,6 +83,12 @@ func (m *matcher) fillParents(nodes ...ast.Node) {
 type submatch struct {
 	node   ast.Node
 	values map[string]ast.Node
+
+	// the -f pattern that found the node, if any
+	label string
+
+	// the replacement suggested by -suggest, if any
+	fix ast.Node
 }
 
 func valsCopy(values map[string]ast.Node) map[string]ast.Node {
@@ -59,6 +99,16 @@ func valsCopy(values map[string]ast.Node) map[string]ast.Node {
 	return v2
 }
 
+// setValue records the value of a wildcard, copying the values map first if
+// it is shared.
+func (m *matcher) setValue(name string, node ast.Node) {
+	if m.valuesShared {
+		m.values = valsCopy(m.values)
+		m.valuesShared = false
+	}
+	m.values[name] = node
+}
+
 func (m *matcher) submatches(cmds []exprCmd, subs []submatch) []submatch {
 	if len(cmds) == 0 {
 		return subs
@@ -68,16 +118,37 @@ func (m *matcher) submatches(cmds []exprCmd, subs []submatch) []submatch {
 	switch cmd.name {
 	case "x":
 		fn = m.cmdRange
+		if _, ok := cmd.value.(*regexp.Regexp); ok {
+			fn = m.cmdRangeRegexp
+		}
+	case "f":
+		fn = m.cmdRangeAny
 	case "g":
 		fn = m.cmdFilter(true)
 	case "v":
 		fn = m.cmdFilter(false)
-	case "s":
+	case "s", "wrap":
 		fn = m.cmdSubst
+	case "suggest":
+		fn = m.cmdSuggest
+	case "bind":
+		fn = m.cmdBind
 	case "a":
 		fn = m.cmdAttr
 	case "p":
 		fn = m.cmdParents
+	case "stmt":
+		fn = m.cmdStmt
+	case "outermost", "innermost":
+		fn = m.cmdNested
+	case "repeated":
+		fn = m.cmdRepeated
+	case "positional", "keyed":
+		fn = m.cmdLitForm
+	case "wrapverb":
+		fn = m.cmdWrapVerb
+	case "directive":
+		fn = m.cmdDirective
 	case "w":
 		if len(cmds) > 1 {
 			panic("-w must be the last command")
@@ -86,7 +157,15 @@ func (m *matcher) submatches(cmds []exprCmd, subs []submatch) []submatch {
 	default:
 		panic(fmt.Sprintf("unknown command: %q", cmd.name))
 	}
-	return m.submatches(cmds[1:], fn(cmd, subs))
+	subs = fn(cmd, subs)
+	switch cmd.name {
+	case "s", "wrap", "p", "stmt", "positional", "keyed", "wrapverb":
+		// suggestions were for the nodes being replaced
+		for i := range subs {
+			subs[i].fix = nil
+		}
+	}
+	return m.submatches(cmds[1:], subs)
 }
 
 func (m *matcher) cmdRange(cmd exprCmd, subs []submatch) []submatch {
@@ -97,32 +176,153 @@ func (m *matcher) cmdRange(cmd exprCmd, subs []submatch) []submatch {
 	// from its parent submatch. If we don't do this copy, all the
 	// submatches would share the same map and have side effects.
 	var startValues map[string]ast.Node
+	var startLabel string
 
 	match := func(exprNode, node ast.Node) {
 		if node == nil {
 			return
 		}
-		m.values = valsCopy(startValues)
+		// most nodes won't match, so only copy the values
+		// once they are modified
+		m.values, m.valuesShared = startValues, true
 		found := m.topNode(exprNode, node)
 		if found == nil {
 			return
 		}
 		hash := posHash(found)
 		if !seen[hash] {
+			if m.valuesShared {
+				m.values = valsCopy(startValues)
+			}
 			matches = append(matches, submatch{
 				node:   found,
 				values: m.values,
+				label:  startLabel,
 			})
 			seen[hash] = true
 		}
 	}
 	for _, sub := range subs {
 		startValues = valsCopy(sub.values)
+		startLabel = sub.label
 		m.walkWithLists(cmd.value.(ast.Node), sub.node, match)
 	}
 	return matches
 }
 
+// cmdBind records the first node matching a pattern within each match, under
+// the binding's name. Unlike -x, the matches themselves are kept.
+func (m *matcher) cmdBind(cmd exprCmd, subs []submatch) []submatch {
+	b := cmd.value.(binding)
+	var kept []submatch
+	for _, sub := range subs {
+		found := m.cmdRange(exprCmd{name: "x", value: b.node}, []submatch{sub})
+		if len(found) == 0 {
+			continue
+		}
+		sub.values = found[0].values
+		sub.values[b.name] = found[0].node
+		kept = append(kept, sub)
+	}
+	return kept
+}
+
+// cmdRangeAny is like cmdRange, but finds the nodes matching any of a number of
+// patterns. The matches are sorted by position.
+func (m *matcher) cmdRangeAny(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	for _, subCmd := range cmd.value.([]exprCmd) {
+		for _, sub := range m.cmdRange(subCmd, subs) {
+			hash := posHash(sub.node)
+			if !seen[hash] {
+				sub.label = subCmd.src
+				matches = append(matches, sub)
+				seen[hash] = true
+			}
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].node.Pos() < matches[j].node.Pos()
+	})
+	return matches
+}
+
+// cmdRangeRegexp finds the top-level declarations whose source, printed in a
+// single line, matches a regular expression.
+func (m *matcher) cmdRangeRegexp(cmd exprCmd, subs []submatch) []submatch {
+	rx := cmd.value.(*regexp.Regexp)
+	var matches []submatch
+	for _, sub := range subs {
+		nodes := []ast.Node{sub.node}
+		if file, ok := sub.node.(*ast.File); ok {
+			nodes = nodes[:0]
+			for _, decl := range file.Decls {
+				nodes = append(nodes, decl)
+			}
+		}
+		for _, node := range nodes {
+			var buf bufferJoinLines
+			printNode(&buf, emptyFset, node)
+			if rx.MatchString(buf.String()) {
+				matches = append(matches, submatch{
+					node:   node,
+					values: valsCopy(sub.values),
+					label:  sub.label,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// cmdDirective finds the directive comments within each node. Since most
+// comments aren't part of the syntax tree, files are searched via their list
+// of comments.
+func (m *matcher) cmdDirective(cmd exprCmd, subs []submatch) []submatch {
+	dir := cmd.value.(directive)
+	var matches []submatch
+	for _, sub := range subs {
+		var groups []*ast.CommentGroup
+		if file, ok := sub.node.(*ast.File); ok {
+			groups = file.Comments
+		} else {
+			inspect(sub.node, func(node ast.Node) bool {
+				if cg, ok := node.(*ast.CommentGroup); ok {
+					groups = append(groups, cg)
+				}
+				return true
+			})
+		}
+		for _, cg := range groups {
+			for _, c := range cg.List {
+				if !dir.matches(c.Text) {
+					continue
+				}
+				matches = append(matches, submatch{
+					node:   c,
+					values: valsCopy(sub.values),
+					label:  sub.label,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// matches reports whether a comment's text is the directive.
+func (d directive) matches(text string) bool {
+	text = strings.TrimPrefix(text, "//")
+	if !strings.HasPrefix(text, d.name) {
+		return false
+	}
+	args := text[len(d.name):]
+	if args != "" && args[0] != ' ' && args[0] != '\t' {
+		return false // a longer name, like go:generated
+	}
+	return d.rx == nil || d.rx.MatchString(strings.TrimSpace(args))
+}
+
 func (m *matcher) cmdFilter(wantAny bool) func(exprCmd, []submatch) []submatch {
 	return func(cmd exprCmd, subs []submatch) []submatch {
 		var matches []submatch
@@ -138,105 +338,1482 @@ func (m *matcher) cmdFilter(wantAny bool) func(exprCmd, []submatch) []submatch {
 		}
 		for _, sub := range subs {
 			any = false
-			m.values = sub.values
+			m.values, m.valuesShared = sub.values, false
 			m.walkWithLists(cmd.value.(ast.Node), sub.node, match)
 			if any == wantAny {
 				matches = append(matches, sub)
 			}
 		}
-		return matches
+		return matches
+	}
+}
+
+func (m *matcher) cmdAttr(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	for _, sub := range subs {
+		m.values, m.valuesShared = sub.values, false
+		attr := cmd.value.(attribute)
+		got := m.attrApplies(sub.node, attr.under)
+		if got == !attr.neg {
+			matches = append(matches, sub)
+		}
+	}
+	return matches
+}
+
+func (m *matcher) cmdParents(cmd exprCmd, subs []submatch) []submatch {
+	for i := range subs {
+		sub := &subs[i]
+		reps := cmd.value.(int)
+		for j := 0; j < reps; j++ {
+			sub.node = m.parentOf(sub.node)
+		}
+	}
+	return subs
+}
+
+// cmdRepeated keeps the matches whose source, printed in a single line, is
+// shared by at least a number of matches. Useful to find duplicated code.
+func (m *matcher) cmdRepeated(cmd exprCmd, subs []submatch) []submatch {
+	srcs := make([]string, len(subs))
+	count := make(map[string]int)
+	for i, sub := range subs {
+		// don't use singleLinePrint, as it modifies the nodes
+		var buf bufferJoinLines
+		printNode(&buf, emptyFset, sub.node)
+		srcs[i] = buf.String()
+		count[srcs[i]]++
+	}
+	var matches []submatch
+	for i, sub := range subs {
+		if count[srcs[i]] >= cmd.value.(int) {
+			matches = append(matches, sub)
+		}
+	}
+	return matches
+}
+
+// cmdStmt replaces each match with its closest enclosing statement. Matches
+// that aren't part of a statement are discarded.
+func (m *matcher) cmdStmt(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	for _, sub := range subs {
+		var node ast.Node
+		m.walkUp(sub.node, func(x, _ ast.Node) bool {
+			switch x.(type) {
+			case ast.Stmt, stmtList:
+				node = x
+				return false
+			}
+			return true
+		})
+		if node == nil {
+			continue
+		}
+		hash := posHash(node)
+		if !seen[hash] {
+			sub.node = node
+			matches = append(matches, sub)
+			seen[hash] = true
+		}
+	}
+	return matches
+}
+
+// cmdNested discards the matches which nest with others. With -outermost, a
+// match contained within another is discarded, such as "a + b" within
+// "a + b + c". With -innermost, a match containing another is discarded
+// instead.
+func (m *matcher) cmdNested(cmd exprCmd, subs []submatch) []submatch {
+	var discard []bool
+	if cmd.name == "outermost" {
+		discard = containedSubs(subs)
+	} else {
+		discard = make([]bool, len(subs))
+		order := subsBySpan(subs)
+		// containing if a later node ends before this one does
+		minEnd := token.NoPos
+		for k := len(order) - 1; k >= 0; k-- {
+			i := order[k]
+			end := subs[i].node.End()
+			if minEnd.IsValid() && minEnd <= end {
+				discard[i] = true
+			}
+			if !minEnd.IsValid() || end < minEnd {
+				minEnd = end
+			}
+		}
+	}
+	var matches []submatch
+	for i, sub := range subs {
+		if !discard[i] {
+			matches = append(matches, sub)
+		}
+	}
+	return matches
+}
+
+// subsBySpan returns the indexes of subs sorted by position, with the larger
+// nodes first.
+func subsBySpan(subs []submatch) []int {
+	order := make([]int, len(subs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		n1, n2 := subs[order[i]].node, subs[order[j]].node
+		if n1.Pos() != n2.Pos() {
+			return n1.Pos() < n2.Pos()
+		}
+		return n1.End() > n2.End()
+	})
+	return order
+}
+
+// containedSubs reports which of subs have a node contained within the node
+// of another.
+func containedSubs(subs []submatch) []bool {
+	contained := make([]bool, len(subs))
+	// contained if an earlier node ends after this one does
+	maxEnd := token.NoPos
+	for _, i := range subsBySpan(subs) {
+		if end := subs[i].node.End(); end <= maxEnd {
+			contained[i] = true
+		} else {
+			maxEnd = end
+		}
+	}
+	return contained
+}
+
+// firstInEachFile keeps the earliest of subs in each file, by position.
+func (m *matcher) firstInEachFile(subs []submatch) []submatch {
+	first := make(map[string]int)
+	for i, sub := range subs {
+		name := m.fset.Position(sub.node.Pos()).Filename
+		if j, ok := first[name]; !ok || sub.node.Pos() < subs[j].node.Pos() {
+			first[name] = i
+		}
+	}
+	var kept []submatch
+	for i, sub := range subs {
+		if first[m.fset.Position(sub.node.Pos()).Filename] == i {
+			kept = append(kept, sub)
+		}
+	}
+	return kept
+}
+
+func (m *matcher) attrApplies(node ast.Node, attr interface{}) bool {
+	if exprStmt, ok := node.(*ast.ExprStmt); ok {
+		// since we prefer matching entire statements, get the
+		// expression from the ExprStmt
+		node = exprStmt.X
+	}
+	if rx, ok := attr.(*regexp.Regexp); ok {
+		ident, ok := node.(*ast.Ident)
+		return ok && rx.MatchString(ident.Name)
+	}
+	if path, ok := attr.(dotPkgPath); ok {
+		ident, ok := node.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		if sel, ok := m.parentOf(ident).(*ast.SelectorExpr); ok && sel.Sel == ident {
+			return false // qualified, not dot-imported
+		}
+		obj := m.Info.Uses[ident]
+		if obj == nil || obj.Pkg() == nil || obj.Parent() != obj.Pkg().Scope() {
+			return false // not a package-level object
+		}
+		return unvendor(obj.Pkg().Path()) == string(path)
+	}
+	if srx, ok := attr.(srcRegexp); ok {
+		// don't use singleLinePrint, as it modifies the nodes
+		var buf bufferJoinLines
+		printNode(&buf, emptyFset, node)
+		return srx.rx.MatchString(buf.String())
+	}
+	if pn, ok := attr.(pkgName); ok {
+		if file := m.enclosingFile(node); file != nil {
+			return pn.rx.MatchString(file.Name.Name)
+		}
+		// the file isn't among the parents
+		return m.pkg != nil && pn.rx.MatchString(m.pkg.Name())
+	}
+	if dt, ok := attr.(diffTypes); ok {
+		t1, t2 := m.boundType(dt.name1), m.boundType(dt.name2)
+		return t1 != nil && t2 != nil && !types.Identical(t1, t2)
+	}
+	if ar, ok := attr.(assignArity); ok {
+		as, ok := node.(*ast.AssignStmt)
+		return ok && len(as.Lhs) == ar.lhs && len(as.Rhs) == ar.rhs
+	}
+	if min, ok := attr.(todoCount); ok {
+		switch node.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+		default:
+			return false
+		}
+		return m.todos(node) >= int(min)
+	}
+	if be, ok := attr.(buildExpr); ok {
+		file := m.enclosingFile(node)
+		return file != nil && be.rx.MatchString(buildConstraint(file))
+	}
+	if cn, ok := attr.(callName); ok {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		fun := call.Fun
+		for {
+			switch x := fun.(type) {
+			case *ast.ParenExpr:
+				fun = x.X
+				continue
+			case *ast.IndexExpr: // an explicit instantiation
+				fun = x.X
+				continue
+			case *ast.IndexListExpr:
+				fun = x.X
+				continue
+			case *ast.SelectorExpr:
+				fun = x.Sel
+			}
+			break
+		}
+		ident, ok := fun.(*ast.Ident)
+		return ok && cn.rx.MatchString(ident.Name)
+	}
+	if path, ok := attr.(fileImport); ok {
+		file := m.enclosingFile(node)
+		if file == nil {
+			return false
+		}
+		for _, imp := range file.Imports {
+			if p, err := strconv.Unquote(imp.Path.Value); err == nil &&
+				unvendor(p) == string(path) {
+				return true
+			}
+		}
+		return false
+	}
+	if path, ok := attr.(pkgPath); ok {
+		ident, ok := node.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		pkg, ok := m.Info.Uses[ident].(*types.PkgName)
+		return ok && unvendor(pkg.Imported().Path()) == string(path)
+	}
+	if attr == typProperty("naked") {
+		ret, ok := node.(*ast.ReturnStmt)
+		return ok && len(ret.Results) == 0 &&
+			m.enclosingResults(ret).NumFields() > 0
+	}
+	if attr == typProperty("reach") {
+		return m.reachable(node)
+	}
+	if attr == typProperty("unused") {
+		ident, ok := node.(*ast.Ident)
+		return ok && m.unusedAfter(ident)
+	}
+	if attr == typProperty("nil") {
+		// the predeclared nil, not a shadowing declaration
+		ident, ok := node.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		_, ok = m.Info.Uses[ident].(*types.Nil)
+		return ok
+	}
+	if attr == typProperty("inloop") {
+		// the init statement of a for loop and the expression of a
+		// range are only evaluated once
+		inLoop := false
+		m.walkUp(node, func(node, parent ast.Node) bool {
+			switch x := parent.(type) {
+			case *ast.ForStmt:
+				inLoop = node != x.Init
+			case *ast.RangeStmt:
+				inLoop = node != x.X
+			case *ast.FuncDecl:
+				return false
+			}
+			return !inLoop
+		})
+		return inLoop
+	}
+	if attr == typProperty("indefer") {
+		// including func literals which are deferred
+		deferred := false
+		m.walkUp(node, func(node, _ ast.Node) bool {
+			switch node.(type) {
+			case *ast.DeferStmt:
+				deferred = true
+				return false
+			case *ast.FuncDecl:
+				return false
+			}
+			return true
+		})
+		return deferred
+	}
+	if attr == typProperty("ptrrecv") || attr == typProperty("valrecv") {
+		recv := m.methodRecv(node)
+		if recv == nil {
+			return false
+		}
+		if _, ok := recv.Type().Underlying().(*types.Interface); ok {
+			return false // neither, as it's an interface method
+		}
+		_, ptr := recv.Type().(*types.Pointer)
+		return ptr == (attr == typProperty("ptrrecv"))
+	}
+	if attr == typProperty("methodvalue") || attr == typProperty("methodexpr") {
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		selection := m.Info.Selections[sel]
+		if selection == nil {
+			return false
+		}
+		if attr == typProperty("methodexpr") {
+			return selection.Kind() == types.MethodExpr
+		}
+		if selection.Kind() != types.MethodVal {
+			return false
+		}
+		// a method call isn't a method value
+		call, ok := m.parentOf(sel).(*ast.CallExpr)
+		return !ok || call.Fun != sel
+	}
+	if attr == typProperty("funclit") {
+		_, ok := node.(*ast.FuncLit)
+		return ok
+	}
+	if attr == typProperty("mapkey") || attr == typProperty("index") {
+		ie, ok := m.parentOf(node).(*ast.IndexExpr)
+		if !ok || ie.Index != node {
+			return false
+		}
+		typ := m.Info.TypeOf(ie.X)
+		if typ == nil {
+			return false
+		}
+		switch u := typ.Underlying().(type) {
+		case *types.Map:
+			return attr == typProperty("mapkey")
+		case *types.Pointer:
+			_, ok := u.Elem().Underlying().(*types.Array)
+			return ok && attr == typProperty("index")
+		case *types.Array, *types.Slice, *types.Basic:
+			return attr == typProperty("index")
+		}
+		return false
+	}
+	if ac, ok := attr.(argCount); ok {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return false
+		}
+		switch n := len(call.Args); ac.op {
+		case "minargs":
+			return n >= ac.n
+		case "maxargs":
+			return n <= ac.n
+		default:
+			return n == ac.n
+		}
+	}
+	if attr == typProperty("badprintf") {
+		call, ok := node.(*ast.CallExpr)
+		return ok && m.badPrintf(call)
+	}
+	if attr == typProperty("exportedfield") {
+		switch x := node.(type) {
+		case *ast.SelectorExpr:
+			sel := m.Info.Selections[x]
+			return sel != nil && sel.Kind() == types.FieldVal &&
+				token.IsExported(x.Sel.Name)
+		case *ast.Field:
+			if _, ok := m.parentOf(m.parentOf(x)).(*ast.StructType); !ok {
+				return false
+			}
+			if len(x.Names) == 0 {
+				// an embedded field, named after its type
+				return token.IsExported(embeddedName(x.Type))
+			}
+			for _, name := range x.Names {
+				if !token.IsExported(name.Name) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	}
+	if attr == typProperty("nowrap") {
+		call, ok := node.(*ast.CallExpr)
+		return ok && len(m.unwrappedErrors(call)) > 0
+	}
+	if attr == typProperty("badctx") {
+		var ft *ast.FuncType
+		switch x := node.(type) {
+		case *ast.FuncDecl:
+			ft = x.Type
+		case *ast.FuncLit:
+			ft = x.Type
+		case *ast.FuncType:
+			ft = x
+		default:
+			return false
+		}
+		return m.ctxNotFirst(ft)
+	}
+	if attr == typProperty("padded") {
+		if gd, ok := node.(*ast.GenDecl); ok && len(gd.Specs) == 1 {
+			node = gd.Specs[0]
+		}
+		if ts, ok := node.(*ast.TypeSpec); ok {
+			node = ts.Type
+		}
+		st, ok := node.(*ast.StructType)
+		if !ok {
+			return false
+		}
+		typ, ok := m.Info.TypeOf(st).(*types.Struct)
+		return ok && paddingSaving(typ) > 0
+	}
+	if attr == typProperty("dupcase") {
+		switch x := node.(type) {
+		case *ast.SwitchStmt:
+			return len(m.dupCases(x)) > 0
+		case *ast.CaseClause:
+			// a clause listing a duplicate value
+			sw, ok := m.parentOf(m.parentOf(x)).(*ast.SwitchStmt)
+			if !ok {
+				return false
+			}
+			for _, dup := range m.dupCases(sw) {
+				if m.parentOf(dup) == x {
+					return true
+				}
+			}
+			return false
+		}
+		// a duplicate value itself
+		cc, ok := m.parentOf(node).(*ast.CaseClause)
+		if !ok {
+			return false
+		}
+		sw, ok := m.parentOf(m.parentOf(cc)).(*ast.SwitchStmt)
+		if !ok {
+			return false
+		}
+		for _, dup := range m.dupCases(sw) {
+			if dup == node {
+				return true
+			}
+		}
+		return false
+	}
+	if attr == typProperty("emptybranch") {
+		return m.emptyBranch(node)
+	}
+	if attr == typProperty("escapes") {
+		pos := m.fset.Position(node.Pos())
+		return m.heapEscapes[token.Position{
+			Filename: pos.Filename,
+			Line:     pos.Line,
+			Column:   pos.Column,
+		}]
+	}
+	if attr == typProperty("badpanic") {
+		call, ok := node.(*ast.CallExpr)
+		return ok && m.badPanic(call)
+	}
+	if attr == typProperty("written") || attr == typProperty("readonly") {
+		ident, ok := node.(*ast.Ident)
+		if !ok {
+			return false
+		}
+		obj := m.Info.Defs[ident]
+		if obj == nil {
+			obj = m.Info.Uses[ident]
+		}
+		v, ok := obj.(*types.Var)
+		if !ok || v.IsField() || v.Pkg() == nil || v.Parent() == v.Pkg().Scope() {
+			return false // only local variables can be fully scanned
+		}
+		// the top-level declaration, such as the func
+		var decl ast.Node
+		m.walkUp(ident, func(node, parent ast.Node) bool {
+			decl = node
+			_, ok := parent.(*ast.File)
+			return !ok
+		})
+		return m.writesVar(decl, v) == (attr == typProperty("written"))
+	}
+	if attr == typProperty("spawn") {
+		if _, ok := node.(*ast.GoStmt); ok {
+			return true
+		}
+		call, ok := node.(*ast.CallExpr)
+		return ok && m.spawnsGoroutine(call)
+	}
+	if attr == typProperty("toplevel") {
+		// package-level declarations, including their specs
+		parent := m.parentOf(node)
+		if _, ok := node.(ast.Spec); ok {
+			parent = m.parentOf(parent)
+		}
+		_, ok := parent.(*ast.File)
+		return ok
+	}
+	if attr == typProperty("ptrbase") {
+		// field selectors through a pointer, or assignments to them
+		if as, ok := node.(*ast.AssignStmt); ok {
+			for _, lhs := range as.Lhs {
+				if m.attrApplies(lhs, attr) {
+					return true
+				}
+			}
+			return false
+		}
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		selection := m.Info.Selections[sel]
+		return selection != nil && selection.Kind() == types.FieldVal &&
+			selection.Indirect()
+	}
+	if attr == typProperty("unkeyed") {
+		// positional fields of structs from other packages, like vet
+		lit, ok := node.(*ast.CompositeLit)
+		if !ok || len(lit.Elts) == 0 {
+			return false
+		}
+		if _, ok := lit.Elts[0].(*ast.KeyValueExpr); ok {
+			return false
+		}
+		typ := m.Info.TypeOf(lit)
+		if ptr, ok := typ.(*types.Pointer); ok {
+			typ = ptr.Elem() // elided &T in a composite literal
+		}
+		named, ok := typ.(*types.Named)
+		if !ok {
+			return false
+		}
+		if _, ok := named.Underlying().(*types.Struct); !ok {
+			return false
+		}
+		return named.Obj().Pkg() != nil && named.Obj().Pkg() != m.pkg
+	}
+	if db, ok := attr.(durBound); ok {
+		expr, ok := node.(ast.Expr)
+		if !ok {
+			return false
+		}
+		d, ok := m.constDuration(expr)
+		if !ok {
+			return false
+		}
+		if db.op == "mindur" {
+			return d >= db.d
+		}
+		return d <= db.d
+	}
+	if attr == typProperty("keyed") || attr == typProperty("positional") {
+		lit, ok := node.(*ast.CompositeLit)
+		if !ok {
+			return false
+		}
+		// empty literals are both keyed and positional
+		for _, elt := range lit.Elts {
+			_, kv := elt.(*ast.KeyValueExpr)
+			if kv != (attr == typProperty("keyed")) {
+				return false
+			}
+		}
+		return true
+	}
+	if kind, ok := attr.(loopKind); ok {
+		switch x := node.(type) {
+		case *ast.RangeStmt:
+			return kind == "range"
+		case *ast.ForStmt:
+			switch {
+			case x.Init != nil || x.Post != nil:
+				return kind == "clause"
+			case x.Cond != nil:
+				return kind == "cond"
+			default:
+				return kind == "infinite"
+			}
+		}
+		return false
+	}
+	if kind, ok := attr.(rangeOver); ok {
+		rs, ok := node.(*ast.RangeStmt)
+		return ok && m.attrApplies(rs.X, typUnderlying(kind))
+	}
+	if tc, ok := attr.(typeCheck); ok && tc.op == "embeds" {
+		st, ok := node.(*ast.StructType)
+		return ok && m.embeds(st, tc.expr)
+	}
+	expr, _ := node.(ast.Expr)
+	if expr == nil {
+		return false // only exprs have types
+	}
+	t := m.Info.TypeOf(expr)
+	if t == nil {
+		return false // an expr, but no type?
+	}
+	tv := m.Info.Types[expr]
+	switch x := attr.(type) {
+	case typeCheck:
+		want := m.resolveType(m.scope, x.expr)
+		switch {
+		case x.op == "type" && !types.Identical(t, want):
+			return false
+		case x.op == "asgn" && !types.AssignableTo(t, want):
+			return false
+		case x.op == "conv" && !types.ConvertibleTo(t, want):
+			return false
+		}
+	case typProperty:
+		switch {
+		case x == "comp" && !types.Comparable(t):
+			return false
+		case x == "addr" && !tv.Addressable():
+			return false
+		case x == "neg" && !negConst(tv.Value):
+			return false
+		case x == "alwaystrue" && !boolConst(tv.Value, true):
+			return false
+		case x == "alwaysfalse" && !boolConst(tv.Value, false):
+			return false
+		}
+	case typUnderlying:
+		u := t.Underlying()
+		uok := true
+		switch x {
+		case "basic":
+			_, uok = u.(*types.Basic)
+		case "array":
+			_, uok = u.(*types.Array)
+		case "slice":
+			_, uok = u.(*types.Slice)
+		case "struct":
+			_, uok = u.(*types.Struct)
+		case "interface":
+			_, uok = u.(*types.Interface)
+		case "pointer":
+			_, uok = u.(*types.Pointer)
+		case "func":
+			_, uok = u.(*types.Signature)
+		case "map":
+			_, uok = u.(*types.Map)
+		case "chan":
+			_, uok = u.(*types.Chan)
+		}
+		if !uok {
+			return false
+		}
+	case typMethod:
+		if !hasMethod(t, string(x), tv.Addressable()) {
+			return false
+		}
+	case typPkgPath:
+		if namedPkgPath(t) != string(x) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasMethod reports whether the method set of t includes a method with the
+// given name, including methods promoted from embedded fields and interfaces.
+// If addr is true, the method set of *t is used instead, since addressable
+// values can call pointer receiver methods too.
+func hasMethod(t types.Type, name string, addr bool) bool {
+	if addr {
+		if _, ok := t.Underlying().(*types.Interface); !ok {
+			if _, ok := t.(*types.Pointer); !ok {
+				t = types.NewPointer(t)
+			}
+		}
+	}
+	mset := types.NewMethodSet(t)
+	for i := 0; i < mset.Len(); i++ {
+		if mset.At(i).Obj().Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// methodRecv returns the receiver of the method called or selected by node,
+// if any.
+func (m *matcher) methodRecv(node ast.Node) *types.Var {
+	if exprStmt, ok := node.(*ast.ExprStmt); ok {
+		node = exprStmt.X
+	}
+	if call, ok := node.(*ast.CallExpr); ok {
+		node = call.Fun
+	}
+	sel, ok := node.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	selection := m.Info.Selections[sel]
+	if selection == nil || selection.Kind() == types.FieldVal {
+		return nil
+	}
+	return selection.Obj().Type().(*types.Signature).Recv()
+}
+
+// errorType is the underlying interface of the predeclared error type.
+var errorType = types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+
+// embeddedName returns the name of an embedded field given its type, such as
+// "Buffer" for "*bytes.Buffer".
+func embeddedName(expr ast.Expr) string {
+	for {
+		switch x := expr.(type) {
+		case *ast.StarExpr:
+			expr = x.X
+		case *ast.SelectorExpr:
+			expr = x.Sel
+		case *ast.IndexExpr:
+			expr = x.X
+		case *ast.Ident:
+			return x.Name
+		default:
+			return ""
+		}
+	}
+}
+
+// ctxNotFirst reports whether a func has a context.Context parameter other
+// than its first one, against the convention.
+func (m *matcher) ctxNotFirst(ft *ast.FuncType) bool {
+	i := 0
+	for _, field := range ft.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1 // unnamed
+		}
+		if i+n > 1 && isNamedType(m.Info.TypeOf(field.Type), "context", "Context") {
+			return true
+		}
+		i += n
+	}
+	return false
+}
+
+// isNamedType reports whether t is the named type with the given package path
+// and name.
+func isNamedType(t types.Type, path, name string) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && unvendor(obj.Pkg().Path()) == path && obj.Name() == name
+}
+
+var stdSizes = types.SizesFor("gc", "amd64")
+
+// paddingSaving returns how many bytes a struct type would save if its fields
+// were sorted to minimize the padding between them, on amd64. Structs with
+// fields whose types depend on type parameters have no known size, so they
+// never save any bytes.
+func paddingSaving(st *types.Struct) int64 {
+	fields := make([]*types.Var, st.NumFields())
+	for i := range fields {
+		fields[i] = st.Field(i)
+		if hasTypeParam(fields[i].Type()) {
+			return 0
+		}
+	}
+	size := stdSizes.Sizeof(st)
+	// zero-sized fields first, as a trailing one adds padding; then by
+	// decreasing alignment and size
+	sort.SliceStable(fields, func(i, j int) bool {
+		t1, t2 := fields[i].Type(), fields[j].Type()
+		s1, s2 := stdSizes.Sizeof(t1), stdSizes.Sizeof(t2)
+		if (s1 == 0) != (s2 == 0) {
+			return s1 == 0
+		}
+		if a1, a2 := stdSizes.Alignof(t1), stdSizes.Alignof(t2); a1 != a2 {
+			return a1 > a2
+		}
+		return s1 > s2
+	})
+	return size - stdSizes.Sizeof(types.NewStruct(fields, nil))
+}
+
+// hasTypeParam reports whether t is or contains a type parameter.
+func hasTypeParam(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.TypeParam:
+		return true
+	case *types.Pointer:
+		return hasTypeParam(t.Elem())
+	case *types.Slice:
+		return hasTypeParam(t.Elem())
+	case *types.Array:
+		return hasTypeParam(t.Elem())
+	case *types.Chan:
+		return hasTypeParam(t.Elem())
+	case *types.Map:
+		return hasTypeParam(t.Key()) || hasTypeParam(t.Elem())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if hasTypeParam(t.Field(i).Type()) {
+				return true
+			}
+		}
+	case *types.Tuple:
+		for i := 0; i < t.Len(); i++ {
+			if hasTypeParam(t.At(i).Type()) {
+				return true
+			}
+		}
+	case *types.Signature:
+		return hasTypeParam(t.Params()) || hasTypeParam(t.Results())
+	case *types.Named:
+		// the underlying type of a named type can't use type parameters
+		// other than its own, which are only bound via type arguments
+		args := t.TypeArgs()
+		for i := 0; i < args.Len(); i++ {
+			if hasTypeParam(args.At(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// badPanic reports whether call is a call to the panic builtin with a value
+// which is neither an error nor a string.
+func (m *matcher) badPanic(call *ast.CallExpr) bool {
+	ident, ok := call.Fun.(*ast.Ident)
+	if !ok || len(call.Args) != 1 {
+		return false
+	}
+	if _, ok := m.Info.Uses[ident].(*types.Builtin); !ok || ident.Name != "panic" {
+		return false
+	}
+	t := m.Info.TypeOf(call.Args[0])
+	if t == nil {
+		return false
+	}
+	if basic, ok := t.Underlying().(*types.Basic); ok && basic.Info()&types.IsString != 0 {
+		return false
+	}
+	return !types.Implements(t, errorType)
+}
+
+// printfFuncs are the functions in fmt which take a printf format, followed by
+// its arguments.
+var printfFuncs = map[string]bool{
+	"Printf":  true,
+	"Sprintf": true,
+	"Fprintf": true,
+	"Errorf":  true,
+	"Appendf": true,
+}
+
+// badPrintf reports whether call is to one of the fmt printf functions, with a
+// constant format string whose verbs don't match the number of arguments.
+func (m *matcher) badPrintf(call *ast.CallExpr) bool {
+	var ident *ast.Ident
+	switch x := call.Fun.(type) {
+	case *ast.Ident:
+		ident = x
+	case *ast.SelectorExpr:
+		ident = x.Sel
+	default:
+		return false
+	}
+	fn, ok := m.Info.Uses[ident].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != "fmt" || !printfFuncs[fn.Name()] {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	if !sig.Variadic() || call.Ellipsis.IsValid() {
+		return false
+	}
+	// the format is the parameter before the variadic one
+	index := sig.Params().Len() - 2
+	if index < 0 || len(call.Args) <= index {
+		return false
+	}
+	format := m.Info.Types[call.Args[index]].Value
+	if format == nil || format.Kind() != constant.String {
+		return false
+	}
+	verbs, ok := printfVerbs(constant.StringVal(format))
+	return ok && verbs != len(call.Args)-index-1
+}
+
+// printfVerbs returns the number of arguments consumed by a printf format
+// string. It returns false if the arguments are indexed explicitly, as then
+// their number can't be known.
+func printfVerbs(format string) (int, bool) {
+	verbs, ok := printfArgVerbs(format)
+	return len(verbs), ok
+}
+
+// printfArgVerbs returns the offset of the verb within a printf format string
+// for each argument it consumes, or -1 for width and precision arguments. It
+// returns false if the arguments are indexed explicitly.
+func printfArgVerbs(format string) ([]int, bool) {
+	var verbs []int
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		for i++; i < len(format); i++ {
+			c := format[i]
+			switch {
+			case c == '[':
+				return nil, false
+			case c == '*':
+				verbs = append(verbs, -1)
+				continue
+			case strings.IndexByte("+-# 0.", c) >= 0,
+				'0' <= c && c <= '9':
+				continue
+			case c != '%':
+				verbs = append(verbs, i)
+			}
+			break
+		}
+	}
+	return verbs, true
+}
+
+// unwrappedErrors returns the offsets within the format string of a fmt.Errorf
+// call of the plain %v and %s verbs formatting errors, if the format has no %w
+// verbs at all.
+func (m *matcher) unwrappedErrors(call *ast.CallExpr) []int {
+	var ident *ast.Ident
+	switch x := call.Fun.(type) {
+	case *ast.Ident:
+		ident = x
+	case *ast.SelectorExpr:
+		ident = x.Sel
+	default:
+		return nil
+	}
+	fn, ok := m.Info.Uses[ident].(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != "fmt" ||
+		fn.Name() != "Errorf" || len(call.Args) < 2 || call.Ellipsis.IsValid() {
+		return nil
+	}
+	val := m.Info.Types[call.Args[0]].Value
+	if val == nil || val.Kind() != constant.String {
+		return nil
+	}
+	format := constant.StringVal(val)
+	verbs, ok := printfArgVerbs(format)
+	if !ok {
+		return nil
+	}
+	var offsets []int
+	for i, off := range verbs {
+		if off >= 0 && format[off] == 'w' {
+			return nil
+		}
+		if i+1 >= len(call.Args) || off < 1 || format[off-1] != '%' {
+			continue // missing argument, or a verb with flags
+		}
+		if format[off] != 'v' && format[off] != 's' {
+			continue
+		}
+		if t := m.Info.TypeOf(call.Args[i+1]); t != nil && types.Implements(t, errorType) {
+			offsets = append(offsets, off)
+		}
+	}
+	return offsets
+}
+
+// negConst reports whether a constant is a negative integer or float. Note that
+// "-5" is a unary expression, not a literal, so it has a constant value but
+// "5" is what's within the BasicLit.
+func negConst(val constant.Value) bool {
+	if val == nil {
+		return false
+	}
+	switch val.Kind() {
+	case constant.Int, constant.Float:
+		return constant.Sign(val) < 0
+	}
+	return false
+}
+
+// boolConst reports whether val is the boolean constant want.
+func boolConst(val constant.Value, want bool) bool {
+	return val != nil && val.Kind() == constant.Bool &&
+		constant.BoolVal(val) == want
+}
+
+// boundType returns the type of the expression bound to a wildcard, or nil if
+// it is unknown.
+func (m *matcher) boundType(name string) types.Type {
+	expr, ok := m.values[name].(ast.Expr)
+	if !ok {
+		return nil
+	}
+	typ := m.Info.TypeOf(expr)
+	if typ == types.Typ[types.Invalid] {
+		return nil
+	}
+	return typ
+}
+
+// emptyBranch reports whether node is a statement with an empty branch, which
+// likely does nothing: an if with an empty body or else, a loop with an empty
+// body, or a switch or select without any cases. Loops which are likely
+// intentional, like "for {}" or draining a channel, aren't reported.
+func (m *matcher) emptyBranch(node ast.Node) bool {
+	switch x := node.(type) {
+	case *ast.IfStmt:
+		if len(x.Body.List) == 0 {
+			return true
+		}
+		els, ok := x.Else.(*ast.BlockStmt)
+		return ok && len(els.List) == 0
+	case *ast.ForStmt:
+		if x.Init == nil && x.Cond == nil && x.Post == nil {
+			return false // blocks forever
+		}
+		return len(x.Body.List) == 0
+	case *ast.RangeStmt:
+		if typ := m.Info.TypeOf(x.X); typ != nil {
+			if _, ok := typ.Underlying().(*types.Chan); ok {
+				return false // drains a channel
+			}
+		}
+		return len(x.Body.List) == 0
+	case *ast.SwitchStmt:
+		return len(x.Body.List) == 0
+	case *ast.TypeSwitchStmt:
+		return len(x.Body.List) == 0
+	case *ast.SelectStmt:
+		return len(x.Body.List) == 0
+	}
+	return false
+}
+
+// dupCases returns the case expressions in a switch statement which repeat an
+// earlier one, either by constant value or by source.
+func (m *matcher) dupCases(sw *ast.SwitchStmt) []ast.Expr {
+	seen := make(map[string]bool)
+	var dups []ast.Expr
+	for _, stmt := range sw.Body.List {
+		for _, expr := range stmt.(*ast.CaseClause).List {
+			var key string
+			if val := m.Info.Types[expr].Value; val != nil {
+				key = "const " + val.ExactString()
+			} else {
+				// don't use singleLinePrint, as it modifies the nodes
+				var buf bufferJoinLines
+				printNode(&buf, emptyFset, expr)
+				key = "src " + buf.String()
+			}
+			if seen[key] {
+				dups = append(dups, expr)
+			}
+			seen[key] = true
+		}
+	}
+	return dups
+}
+
+// constDuration returns the value of a constant time.Duration expression, such
+// as "5 * time.Second".
+func (m *matcher) constDuration(expr ast.Expr) (time.Duration, bool) {
+	tv := m.Info.Types[expr]
+	named, ok := tv.Type.(*types.Named)
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.Int ||
+		named.Obj().Pkg() == nil ||
+		named.Obj().Pkg().Path() != "time" || named.Obj().Name() != "Duration" {
+		return 0, false
+	}
+	n, exact := constant.Int64Val(tv.Value)
+	return time.Duration(n), exact
+}
+
+// spawnMethods are the methods known to start a goroutine running the func
+// they are given, by the import path and name of their receiver type.
+var spawnMethods = map[string]map[string]bool{
+	"sync.WaitGroup":                   {"Go": true},
+	"golang.org/x/sync/errgroup.Group": {"Go": true, "TryGo": true},
+}
+
+// spawnsGoroutine reports whether call is to one of spawnMethods.
+func (m *matcher) spawnsGoroutine(call *ast.CallExpr) bool {
+	recv := m.methodRecv(call)
+	if recv == nil {
+		return false
+	}
+	typ := recv.Type()
+	if ptr, ok := typ.(*types.Pointer); ok {
+		typ = ptr.Elem()
+	}
+	named, ok := typ.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+	name := unvendor(named.Obj().Pkg().Path()) + "." + named.Obj().Name()
+	sel := call.Fun.(*ast.SelectorExpr) // per methodRecv
+	return spawnMethods[name][sel.Sel.Name]
+}
+
+// namedPkgPath returns the import path of the package declaring the named
+// type t, looking through one level of pointer or slice. It returns the empty
+// string if there is no such package.
+func namedPkgPath(t types.Type) string {
+	switch x := t.(type) {
+	case *types.Pointer:
+		t = x.Elem()
+	case *types.Slice:
+		t = x.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return "" // not named, or builtin like error
+	}
+	return unvendor(named.Obj().Pkg().Path())
+}
+
+// unvendor strips the vendor directory prefix from an import path, so that
+// vendored copies of a package have the same path as the original. For
+// example, "foo.com/bar/vendor/baz.com/qux" becomes "baz.com/qux".
+func unvendor(path string) string {
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		return path[i+len("/vendor/"):]
+	}
+	return strings.TrimPrefix(path, "vendor/")
+}
+
+// embeds reports whether a struct type has an embedded field of the given
+// type. Types are compared via type information if available, and by their
+// syntax otherwise.
+func (m *matcher) embeds(st *ast.StructType, typ ast.Expr) bool {
+	want := m.resolveType(m.scope, typ)
+	for _, field := range st.Fields.List {
+		if len(field.Names) > 0 {
+			continue // not embedded
+		}
+		if got := m.Info.TypeOf(field.Type); got != nil && want != nil {
+			if types.Identical(got, want) {
+				return true
+			}
+		} else if types.ExprString(field.Type) == types.ExprString(typ) {
+			return true
+		}
 	}
+	return false
 }
 
-func (m *matcher) cmdAttr(cmd exprCmd, subs []submatch) []submatch {
-	var matches []submatch
-	for _, sub := range subs {
-		m.values = sub.values
-		attr := cmd.value.(attribute)
-		got := m.attrApplies(sub.node, attr.under)
-		if got == !attr.neg {
-			matches = append(matches, sub)
+// todos returns the number of TODO and FIXME comments within a func, including
+// its doc comment. Comments are only available for entire files.
+func (m *matcher) todos(fn ast.Node) int {
+	file := m.enclosingFile(fn)
+	if file == nil {
+		return 0
+	}
+	pos, end := fn.Pos(), fn.End()
+	if decl, ok := fn.(*ast.FuncDecl); ok && decl.Doc != nil {
+		pos = decl.Doc.Pos()
+	}
+	n := 0
+	for _, cg := range file.Comments {
+		if cg.End() < pos || cg.Pos() > end {
+			continue
+		}
+		for _, c := range cg.List {
+			if c.Pos() >= pos && c.End() <= end &&
+				(strings.Contains(c.Text, "TODO") || strings.Contains(c.Text, "FIXME")) {
+				n++
+			}
 		}
 	}
-	return matches
+	return n
 }
 
-func (m *matcher) cmdParents(cmd exprCmd, subs []submatch) []submatch {
-	for i := range subs {
-		sub := &subs[i]
-		reps := cmd.value.(int)
-		for j := 0; j < reps; j++ {
-			sub.node = m.parentOf(sub.node)
+// enclosingFile returns the file containing node, or nil if it isn't among
+// its parents.
+func (m *matcher) enclosingFile(node ast.Node) *ast.File {
+	var file *ast.File
+	m.walkUp(node, func(node, _ ast.Node) bool {
+		file, _ = node.(*ast.File)
+		return file == nil
+	})
+	return file
+}
+
+// enclosingResults returns the results of the function declaration or literal
+// containing node, or nil if there is none.
+func (m *matcher) enclosingResults(node ast.Node) *ast.FieldList {
+	var results *ast.FieldList
+	m.walkUp(node, func(_, parent ast.Node) bool {
+		switch x := parent.(type) {
+		case *ast.FuncDecl:
+			results = x.Type.Results
+		case *ast.FuncLit:
+			results = x.Type.Results
+		default:
+			return true
 		}
-	}
-	return subs
+		return false
+	})
+	return results
 }
 
-func (m *matcher) attrApplies(node ast.Node, attr interface{}) bool {
-	if rx, ok := attr.(*regexp.Regexp); ok {
-		if exprStmt, ok := node.(*ast.ExprStmt); ok {
-			// since we prefer matching entire statements, get the
-			// ident from the ExprStmt
-			node = exprStmt.X
+// reachable reports whether node is not obviously dead code. That is, whether
+// none of the blocks containing it have a terminating statement before it.
+// There is no control flow analysis, so the check is conservative.
+func (m *matcher) reachable(node ast.Node) bool {
+	if list, ok := node.(nodeList); ok {
+		node = list.at(0)
+	}
+	reach := true
+	m.walkUp(node, func(node, parent ast.Node) bool {
+		for _, stmt := range blockStmts(parent) {
+			if stmt == node {
+				break
+			}
+			if m.terminates(stmt) {
+				reach = false
+				return false
+			}
 		}
-		ident, ok := node.(*ast.Ident)
-		return ok && rx.MatchString(ident.Name)
+		return true
+	})
+	return reach
+}
+
+// blockStmts returns the list of statements directly within node, if it holds
+// any.
+func blockStmts(node ast.Node) []ast.Stmt {
+	switch x := node.(type) {
+	case *ast.BlockStmt:
+		return x.List
+	case *ast.CaseClause:
+		return x.Body
+	case *ast.CommClause:
+		return x.Body
+	case stmtList:
+		return x
 	}
-	expr, _ := node.(ast.Expr)
-	if expr == nil {
-		return false // only exprs have types
+	return nil
+}
+
+// unusedAfter reports whether the variable assigned to by id is never read by
+// the statements that follow, before it is assigned to again or the function
+// returns. Like reachable, it does not follow the control flow.
+func (m *matcher) unusedAfter(id *ast.Ident) bool {
+	obj := m.Info.Defs[id]
+	if obj == nil {
+		obj = m.Info.Uses[id]
 	}
-	t := m.Info.TypeOf(expr)
-	if t == nil {
-		return false // an expr, but no type?
+	v, ok := obj.(*types.Var)
+	if !ok {
+		return false
 	}
-	tv := m.Info.Types[expr]
-	switch x := attr.(type) {
-	case typeCheck:
-		want := m.resolveType(m.scope, x.expr)
-		switch {
-		case x.op == "type" && !types.Identical(t, want):
-			return false
-		case x.op == "asgn" && !types.AssignableTo(t, want):
+	var node ast.Node
+	switch x := m.parentOf(id).(type) {
+	case *ast.AssignStmt:
+		if !isLhs(x, v, m.Info) {
 			return false
-		case x.op == "conv" && !types.ConvertibleTo(t, want):
+		}
+		node = x
+	case *ast.ValueSpec:
+		// e.g. "var x = f()", but not "var x T"
+		if len(x.Values) == 0 || m.Info.Defs[id] != v {
 			return false
 		}
-	case typProperty:
-		switch {
-		case x == "comp" && !types.Comparable(t):
+		node = x
+	default:
+		return false
+	}
+	unused := true
+	m.walkUp(node, func(node, parent ast.Node) bool {
+		switch x := parent.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
 			return false
-		case x == "addr" && !tv.Addressable():
+		case *ast.File:
+			unused = false // a global variable
 			return false
+		case *ast.IfStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.ForStmt:
+			// e.g. "if err := f(); err != nil {"
+			if initStmt(x) == node && m.readsVar(x, v) {
+				unused = false
+				return false
+			}
 		}
-	case typUnderlying:
-		u := t.Underlying()
-		uok := true
-		switch x {
-		case "basic":
-			_, uok = u.(*types.Basic)
-		case "array":
-			_, uok = u.(*types.Array)
-		case "slice":
-			_, uok = u.(*types.Slice)
-		case "struct":
-			_, uok = u.(*types.Struct)
-		case "interface":
-			_, uok = u.(*types.Interface)
-		case "pointer":
-			_, uok = u.(*types.Pointer)
-		case "func":
-			_, uok = u.(*types.Signature)
-		case "map":
-			_, uok = u.(*types.Map)
-		case "chan":
-			_, uok = u.(*types.Chan)
+		after := false
+		for _, stmt := range blockStmts(parent) {
+			if stmt == node {
+				after = true
+				continue
+			}
+			if !after {
+				continue
+			}
+			if m.readsVar(stmt, v) {
+				unused = false
+				return false
+			}
+			if as, ok := stmt.(*ast.AssignStmt); ok && isLhs(as, v, m.Info) {
+				return false
+			}
+			if m.terminates(stmt) {
+				return false
+			}
 		}
-		if !uok {
+		return true
+	})
+	return unused
+}
+
+func initStmt(stmt ast.Node) ast.Stmt {
+	switch x := stmt.(type) {
+	case *ast.IfStmt:
+		return x.Init
+	case *ast.SwitchStmt:
+		return x.Init
+	case *ast.TypeSwitchStmt:
+		return x.Init
+	case *ast.ForStmt:
+		return x.Init
+	}
+	return nil
+}
+
+// isLhs reports whether an assignment stores into the variable v.
+func isLhs(as *ast.AssignStmt, v *types.Var, info *types.Info) bool {
+	for _, lhs := range as.Lhs {
+		id, ok := lhs.(*ast.Ident)
+		if ok && (info.Defs[id] == v || info.Uses[id] == v) {
+			return true
+		}
+	}
+	return false
+}
+
+// writesVar reports whether node stores into the variable v after its
+// declaration. Taking its address counts too, as it may be written through
+// the pointer.
+func (m *matcher) writesVar(node ast.Node, v *types.Var) bool {
+	found := false
+	isVar := func(expr ast.Expr) bool {
+		id, ok := expr.(*ast.Ident)
+		// Uses, as Defs would be the declaration itself
+		return ok && m.Info.Uses[id] == v
+	}
+	inspect(node, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.AssignStmt:
+			for _, lhs := range x.Lhs {
+				found = found || isVar(lhs)
+			}
+		case *ast.IncDecStmt:
+			found = found || isVar(x.X)
+		case *ast.RangeStmt:
+			if x.Tok == token.ASSIGN {
+				found = found || isVar(x.Key) || isVar(x.Value)
+			}
+		case *ast.UnaryExpr:
+			if x.Op == token.AND {
+				found = found || isVar(x.X)
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// readsVar reports whether node reads the variable v. Plain assignments to v
+// don't count, but naked returns do if v is a named result.
+func (m *matcher) readsVar(node ast.Node, v *types.Var) bool {
+	found := false
+	ast.Inspect(node, func(node ast.Node) bool {
+		if found {
+			return false
+		}
+		switch x := node.(type) {
+		case *ast.AssignStmt:
+			if x.Tok != token.ASSIGN {
+				break
+			}
+			for _, lhs := range x.Lhs {
+				if id, ok := lhs.(*ast.Ident); !ok || m.Info.Uses[id] != v {
+					found = found || m.readsVar(lhs, v)
+				}
+			}
+			for _, rhs := range x.Rhs {
+				found = found || m.readsVar(rhs, v)
+			}
+			return false
+		case *ast.Ident:
+			found = m.Info.Uses[x] == v
+		case *ast.ReturnStmt:
+			results := m.enclosingResults(x)
+			if len(x.Results) > 0 || results == nil {
+				break
+			}
+			for _, field := range results.List {
+				for _, name := range field.Names {
+					found = found || m.Info.Defs[name] == v
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// terminates reports whether stmt is a return, or a call to panic or os.Exit.
+func (m *matcher) terminates(stmt ast.Stmt) bool {
+	switch x := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.ExprStmt:
+		call, ok := x.X.(*ast.CallExpr)
+		if !ok {
 			return false
 		}
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			if obj := m.Info.Uses[fun]; obj != nil {
+				_, ok := obj.(*types.Builtin)
+				return ok && obj.Name() == "panic"
+			}
+			return fun.Name == "panic"
+		case *ast.SelectorExpr:
+			id, ok := fun.X.(*ast.Ident)
+			if !ok || fun.Sel.Name != "Exit" {
+				return false
+			}
+			if pkg, ok := m.Info.Uses[id].(*types.PkgName); ok {
+				return pkg.Imported().Path() == "os"
+			}
+			return id.Name == "os"
+		}
 	}
-	return true
+	return false
 }
 
 func (m *matcher) walkWithLists(exprNode, node ast.Node, fn func(exprNode, node ast.Node)) {
@@ -249,6 +1826,10 @@ func (m *matcher) walkWithLists(exprNode, node ast.Node, fn func(exprNode, node
 				fn(exprList([]ast.Expr{id}), list)
 				// so that "$*a" will match "a; b"
 				fn(toStmtList(id), list)
+				if _, ok := list.(fieldList); ok {
+					// so that "$*a" will match "a int, b string"
+					fn(fieldList{{Type: id}}, list)
+				}
 			}
 		}
 		return true
@@ -289,6 +1870,10 @@ func (m *matcher) node(expr, node ast.Node) bool {
 			m.scope = scope
 		}
 	}
+	if node == nil && m.wildOptIdent(expr) != nil {
+		// $?x matching a missing node
+		return true
+	}
 	if !m.aggressive {
 		if expr == nil || node == nil {
 			return expr == node
@@ -345,9 +1930,19 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		prev, ok := m.values[info.name]
 		if !ok {
 			// first occurrence, record value
-			m.values[info.name] = node
+			m.setValue(info.name, node)
 			return true
 		}
+		if list, ok := prev.(nodeList); ok {
+			// a list bound with $*name matches each of its
+			// nodes when used as $name
+			for i := 0; i < list.len(); i++ {
+				if m.node(list.at(i), node) {
+					return true
+				}
+			}
+			return false
+		}
 		// multiple uses must match
 		return m.node(prev, node)
 
@@ -358,6 +1953,10 @@ func (m *matcher) node(expr, node ast.Node) bool {
 	case stmtList:
 		y, ok := node.(stmtList)
 		return ok && m.stmts(x, y)
+	case fieldList:
+		// e.g. the value of $*params in a previous pattern
+		y, ok := node.(fieldList)
+		return ok && m.nodesMatch(x, y)
 
 	// lits
 	case *ast.BasicLit:
@@ -373,7 +1972,13 @@ func (m *matcher) node(expr, node ast.Node) bool {
 	// types
 	case *ast.ArrayType:
 		y, ok := node.(*ast.ArrayType)
-		return ok && m.node(x.Len, y.Len) && m.node(x.Elt, y.Elt)
+		if !ok {
+			return false
+		}
+		if !m.node(x.Len, y.Len) && !(m.aggressive && m.sameConst(x.Len, y.Len)) {
+			return false
+		}
+		return m.node(x.Elt, y.Elt)
 	case *ast.MapType:
 		y, ok := node.(*ast.MapType)
 		return ok && m.node(x.Key, y.Key) && m.node(x.Value, y.Value)
@@ -414,7 +2019,21 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		return ok && x.Op == y.Op && m.node(x.X, y.X)
 	case *ast.BinaryExpr:
 		y, ok := node.(*ast.BinaryExpr)
-		return ok && x.Op == y.Op && m.node(x.X, y.X) && m.node(x.Y, y.Y)
+		if !ok || x.Op != y.Op {
+			return false
+		}
+		if !m.aggressive || !m.commutative(y) {
+			return m.node(x.X, y.X) && m.node(x.Y, y.Y)
+		}
+		// try both orders, discarding the values recorded by the
+		// first if it doesn't match
+		values, shared := m.values, m.valuesShared
+		m.valuesShared = true
+		if m.node(x.X, y.X) && m.node(x.Y, y.Y) {
+			return true
+		}
+		m.values, m.valuesShared = values, shared
+		return m.node(x.X, y.Y) && m.node(x.Y, y.X)
 	case *ast.CallExpr:
 		y, ok := node.(*ast.CallExpr)
 		return ok && m.node(x.Fun, y.Fun) && m.exprs(x.Args, y.Args) &&
@@ -492,6 +2111,15 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		return ok && x.Tok == y.Tok && m.node(x.X, y.X)
 	case *ast.AssignStmt:
 		y, ok := node.(*ast.AssignStmt)
+		if op := m.wildAssignOp(x); op != nil {
+			// "$x $op= $y" on the left
+			if !ok || y.Tok == token.ASSIGN || y.Tok == token.DEFINE {
+				return false
+			}
+			opIdent := &ast.Ident{NamePos: y.TokPos, Name: y.Tok.String()}
+			return m.node(op, opIdent) &&
+				m.exprs(x.Lhs[:len(x.Lhs)-1], y.Lhs) && m.exprs(x.Rhs, y.Rhs)
+		}
 		if !m.aggressive {
 			return ok && x.Tok == y.Tok &&
 				m.exprs(x.Lhs, y.Lhs) && m.exprs(x.Rhs, y.Rhs)
@@ -541,19 +2169,34 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		return m.optNode(x.Init, y.Init) && m.node(x.Cond, y.Cond) &&
 			m.node(x.Body, y.Body) && m.node(x.Else, y.Else)
 	case *ast.CaseClause:
+		if x.List == nil {
+			// "default:" also matches select default clauses
+			if y, ok := node.(*ast.CommClause); ok {
+				return y.Comm == nil && m.stmts(x.Body, y.Body)
+			}
+		}
 		y, ok := node.(*ast.CaseClause)
 		return ok && m.exprs(x.List, y.List) && m.stmts(x.Body, y.Body)
 	case *ast.SwitchStmt:
-		y, ok := node.(*ast.SwitchStmt)
-		if !ok {
-			return false
-		}
 		tagAny := m.wildAnyIdent(x.Tag)
 		if tagAny != nil && x.Init == nil {
 			// switch $*x { ... } on the left
 			left := toStmtList(tagAny)
-			return m.node(left, toStmtList(y.Init, y.Tag)) &&
-				m.node(x.Body, y.Body)
+			// also accept TypeSwitchStmt on the right
+			switch y := node.(type) {
+			case *ast.SwitchStmt:
+				return m.node(left, toStmtList(y.Init, y.Tag)) &&
+					m.node(x.Body, y.Body)
+			case *ast.TypeSwitchStmt:
+				return m.node(left, toStmtList(y.Init, y.Assign)) &&
+					m.node(x.Body, y.Body)
+			default:
+				return false
+			}
+		}
+		y, ok := node.(*ast.SwitchStmt)
+		if !ok {
+			return false
 		}
 		return m.optNode(x.Init, y.Init) && m.node(x.Tag, y.Tag) && m.node(x.Body, y.Body)
 	case *ast.TypeSwitchStmt:
@@ -602,6 +2245,10 @@ func (m *matcher) node(expr, node ast.Node) bool {
 	case *ast.TypeSpec:
 		y, ok := node.(*ast.TypeSpec)
 		return ok && m.node(x.Name, y.Name) && m.node(x.Type, y.Type)
+	case *ast.ImportSpec:
+		y, ok := node.(*ast.ImportSpec)
+		return ok && m.node(maybeNilIdent(x.Name), maybeNilIdent(y.Name)) &&
+			m.node(importPathNode(x.Path), y.Path)
 
 	case *ast.FieldList:
 		// we ignore these, for now
@@ -611,6 +2258,53 @@ func (m *matcher) node(expr, node ast.Node) bool {
 	}
 }
 
+// commutative reports whether the operands of a binary expression can be
+// swapped without changing its result. String concatenation is not.
+func (m *matcher) commutative(expr *ast.BinaryExpr) bool {
+	switch expr.Op {
+	case token.EQL, token.NEQ, token.MUL, token.LAND, token.LOR,
+		token.AND, token.OR, token.XOR:
+		return true
+	case token.ADD:
+		tv := m.Info.Types[expr]
+		if tv.Type == nil {
+			return false
+		}
+		basic, ok := tv.Type.Underlying().(*types.Basic)
+		return ok && basic.Info()&types.IsString == 0
+	}
+	return false
+}
+
+// sameConst reports whether a constant expression in a pattern has the same
+// value as a type-checked constant expression in the source, such as "2" and
+// "1+1".
+func (m *matcher) sameConst(expr, node ast.Expr) bool {
+	if expr == nil || node == nil {
+		return false
+	}
+	want, err := types.Eval(token.NewFileSet(), nil, token.NoPos, types.ExprString(expr))
+	if err != nil || want.Value == nil {
+		return false // not a constant, or uses wildcards
+	}
+	got := m.Info.Types[node].Value
+	return got != nil && constant.Compare(want.Value, token.EQL, got)
+}
+
+// wildAssignOp returns the wildcard standing for the operator of a compound
+// assignment pattern like "$x $op= $y", if any. It's the last expression on the
+// left side.
+func (m *matcher) wildAssignOp(as *ast.AssignStmt) *ast.Ident {
+	if as.Tok != token.ASSIGN || len(as.Lhs) < 2 {
+		return nil
+	}
+	id, ok := as.Lhs[len(as.Lhs)-1].(*ast.Ident)
+	if !ok || !m.info(fromWildName(id.Name)).assignOp {
+		return nil
+	}
+	return id
+}
+
 func (m *matcher) wildAnyIdent(node ast.Node) *ast.Ident {
 	switch x := node.(type) {
 	case *ast.ExprStmt:
@@ -627,6 +2321,22 @@ func (m *matcher) wildAnyIdent(node ast.Node) *ast.Ident {
 	return nil
 }
 
+func (m *matcher) wildOptIdent(node ast.Node) *ast.Ident {
+	switch x := node.(type) {
+	case *ast.ExprStmt:
+		return m.wildOptIdent(x.X)
+	case *ast.Ident:
+		if !isWildName(x.Name) {
+			return nil
+		}
+		if !m.info(fromWildName(x.Name)).optional {
+			return nil
+		}
+		return x
+	}
+	return nil
+}
+
 // resolveType resolves a type expression from a given scope.
 func (m *matcher) resolveType(scope *types.Scope, expr ast.Expr) types.Type {
 	switch x := expr.(type) {
@@ -664,11 +2374,56 @@ func (m *matcher) resolveType(scope *types.Scope, expr ast.Expr) types.Type {
 	case *ast.SelectorExpr:
 		scope = m.findScope(scope, x.X)
 		return m.resolveType(scope, x.Sel)
+	case *ast.InterfaceType:
+		var methods []*types.Func
+		var embeddeds []types.Type
+		for _, field := range x.Methods.List {
+			typ := m.resolveType(scope, field.Type)
+			if len(field.Names) == 0 {
+				embeddeds = append(embeddeds, typ)
+				continue
+			}
+			sig, _ := typ.(*types.Signature)
+			for _, name := range field.Names {
+				methods = append(methods, types.NewFunc(token.NoPos, nil, name.Name, sig))
+			}
+		}
+		return types.NewInterfaceType(methods, embeddeds).Complete()
+	case *ast.FuncType:
+		params, variadic := m.resolveFields(scope, x.Params)
+		results, _ := m.resolveFields(scope, x.Results)
+		return types.NewSignatureType(nil, nil, nil, params, results, variadic)
+	case *ast.MapType:
+		return types.NewMap(m.resolveType(scope, x.Key), m.resolveType(scope, x.Value))
 	default:
 		panic(fmt.Sprintf("resolveType TODO: %T", x))
 	}
 }
 
+// resolveFields resolves the types of a list of parameters or results into a
+// tuple, also reporting whether the last of them is variadic.
+func (m *matcher) resolveFields(scope *types.Scope, list *ast.FieldList) (*types.Tuple, bool) {
+	if list == nil {
+		return nil, false
+	}
+	var vars []*types.Var
+	variadic := false
+	for _, field := range list.List {
+		expr := field.Type
+		if ell, ok := expr.(*ast.Ellipsis); ok {
+			expr, variadic = ell.Elt, true
+		}
+		typ := m.resolveType(scope, expr)
+		if variadic {
+			typ = types.NewSlice(typ)
+		}
+		for i := 0; i < len(field.Names) || i == 0; i++ {
+			vars = append(vars, types.NewParam(token.NoPos, nil, "", typ))
+		}
+	}
+	return types.NewTuple(vars...), variadic
+}
+
 func (m *matcher) findScope(scope *types.Scope, expr ast.Expr) *types.Scope {
 	switch x := expr.(type) {
 	case *ast.Ident:
@@ -864,7 +2619,7 @@ func (m *matcher) nodes(ns1, ns2 nodeList, partial bool) ast.Node {
 	}
 	pop := func() {
 		i1, i2 = next1, next2
-		m.values = stack[len(stack)-1].matches
+		m.values, m.valuesShared = stack[len(stack)-1].matches, false
 		stack = stack[:len(stack)-1]
 		next1, next2 = 0, 0
 		if len(stack) > 0 {
@@ -888,7 +2643,7 @@ func (m *matcher) nodes(ns1, ns2 nodeList, partial bool) ast.Node {
 		if ok && !m.node(prev, list) {
 			return false
 		}
-		m.values[wildName] = list
+		m.setValue(wildName, list)
 		return true
 	}
 	for i1 < ns1len || i2 < ns2len {
@@ -896,7 +2651,7 @@ func (m *matcher) nodes(ns1, ns2 nodeList, partial bool) ast.Node {
 			n1 := ns1.at(i1)
 			id := fromWildNode(n1)
 			info := m.info(id)
-			if info.any {
+			if info.any || info.optional {
 				// keep track of where this wildcard
 				// started (if info.name == wildName,
 				// we're trying the same wildcard
@@ -906,8 +2661,11 @@ func (m *matcher) nodes(ns1, ns2 nodeList, partial bool) ast.Node {
 					wildName = info.name
 				}
 				// try to match zero or more at i2,
-				// restarting at i2+1 if it fails
-				push(i1, i2+1)
+				// restarting at i2+1 if it fails; $?x
+				// can only match up to one node
+				if !info.optional || i2 == wildStart {
+					push(i1, i2+1)
+				}
 				i1++
 				continue
 			}
@@ -1053,6 +2811,15 @@ func fromWildNode(node ast.Node) int {
 	return -1
 }
 
+// importPathNode returns the wildcard identifier that an import path such as
+// "$x" stands for, or the path itself if it isn't a wildcard.
+func importPathNode(path *ast.BasicLit) ast.Node {
+	if name, err := strconv.Unquote(path.Value); err == nil && isWildName(name) {
+		return &ast.Ident{NamePos: path.ValuePos, Name: name}
+	}
+	return path
+}
+
 func nodeLists(n ast.Node) []nodeList {
 	var lists []nodeList
 	addList := func(list nodeList) {
@@ -1081,6 +2848,8 @@ func nodeLists(n ast.Node) []nodeList {
 		addList(stmtList(x.Body))
 	case *ast.CommClause:
 		addList(stmtList(x.Body))
+	case *ast.FieldList:
+		addList(fieldList(x.List))
 	}
 	return lists
 }
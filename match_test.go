@@ -10,6 +10,7 @@ import (
 	"go/importer"
 	"go/token"
 	"go/types"
+	"strings"
 	"testing"
 )
 
@@ -30,6 +31,30 @@ func TestErrors(t *testing.T) {
 		{[]string{"-x", `"`}, tokErr(`1:1: string literal not terminated`)},
 		{[]string{"-x", ""}, parseErr(`empty source code`)},
 		{[]string{"-x", "\t"}, parseErr(`empty source code`)},
+		{
+			[]string{"-lang", "1.21", "-x", "$x"},
+			wantErr(`invalid Go version: "1.21"`),
+		},
+		{
+			[]string{"-tabwidth", "-2", "-x", "$x"},
+			wantErr(`invalid tab width: -2`),
+		},
+		{
+			[]string{"-json", "-json-lines", "-x", "$x"},
+			wantErr(`cannot use -json and -json-lines together`),
+		},
+		{
+			[]string{"-stats", "-x", "$x"},
+			wantErr(`cannot use -stats with commands`),
+		},
+		{
+			[]string{"-directive", "//"},
+			wantErr(`empty directive name`),
+		},
+		{
+			[]string{"-directive", "go:generate ("},
+			wantErr("error parsing regexp: missing closing ): `(`"),
+		},
 		{
 			[]string{"-x", "$x", "-a", "a"},
 			modErr(`1:2: wanted (`),
@@ -38,10 +63,58 @@ func TestErrors(t *testing.T) {
 			[]string{"-x", "$x", "-a", "a("},
 			modErr(`1:1: unknown op "a"`),
 		},
+		{
+			[]string{"-x", "$x", "-a", "pkg(fmt)"},
+			modErr(`1:5: invalid syntax`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "args(a)"},
+			modErr(`1:6: wanted number, got IDENT`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "difftype($x)"},
+			modErr(`1:12: wanted comma, got )`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "difftype($x, y)"},
+			modErr(`1:14: wanted wildcard, got IDENT`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "arity(1 2)"},
+			modErr(`1:9: wanted comma, got INT`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "method(1)"},
+			modErr(`1:8: wanted method name, got INT`),
+		},
 		{
 			[]string{"-x", "$x", "-a", "is(foo)"},
 			modErr(`1:4: unknown type: "foo"`),
 		},
+		{
+			[]string{"-x", "$x", "-a", `mindur("5 mins")`},
+			modErr(`1:8: time: unknown unit " mins" in duration "5 mins"`),
+		},
+		{
+			[]string{"-x", "$x", "-s", "@testdata/noexist.txt"},
+			wantErr("open testdata/noexist.txt: no such file or directory"),
+		},
+		{
+			[]string{"-x", "$x", "-bind", "x"},
+			wantErr(`wanted a name and a pattern: "x"`),
+		},
+		{
+			[]string{"-x", "$x", "-bind", "$_ foo()"},
+			wantErr(`invalid binding name: "$_"`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "loop(while)"},
+			modErr(`1:6: unknown loop kind: "while"`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "range(foo)"},
+			modErr(`1:7: unknown type: "foo"`),
+		},
 		{
 			[]string{"-x", "$x", "-a", "type("},
 			modErr(`1:5: expected ) to close (`),
@@ -69,7 +142,16 @@ func TestErrors(t *testing.T) {
 		{[]string{"-x", "$x)"}, parseErr(`1:3: expected statement, found ')'`)},
 		{[]string{"-x", "$x("}, parseErr(`1:5: expected operand, found '}'`)},
 		{[]string{"-x", "$*x)"}, parseErr(`1:4: expected statement, found ')'`)},
+		// positions count bytes, like go/scanner
+		{[]string{"-x", "héllo + $x)"}, parseErr(`1:12: expected statement, found ')'`)},
+		{[]string{"-x", "$x + héllo)"}, parseErr(`1:12: expected statement, found ')'`)},
+		{[]string{"-x", `f("日本", $x))`}, parseErr(`1:16: expected statement, found ')'`)},
+		{[]string{"-x", "$?"}, tokErr(`1:3: $ must be followed by ident, got EOF`)},
 		{[]string{"-x", "a\n$x)"}, parseErr(`2:3: expected statement, found ')'`)},
+		{[]string{"-x", "expr: a := b"}, parseErr(`1:9: missing ',' in composite literal`)},
+		{[]string{"-x", "decl: a"}, parseErr(`1:7: expected declaration, found a`)},
+		{[]string{"-x", "type: 1"}, parseErr(`1:7: expected type, found 1`)},
+		{[]string{"-x", "file: a"}, parseErr(`1:7: expected 'package', found a`)},
 	}
 	for i, tc := range tests {
 		t.Run(fmt.Sprintf("%03d", i), func(t *testing.T) {
@@ -135,6 +217,87 @@ func TestMatch(t *testing.T) {
 			"foobar; barfoo; foo; barbar", 2,
 		},
 
+		// source regex matches
+		{
+			[]string{"-x", "var $_ $x", "-x", "$x", "-a", "srcrx(`Mutex`)"},
+			`import "sync"; var mu sync.Mutex`, 1,
+		},
+		{
+			[]string{"-x", "var $_ $x", "-x", "$x", "-a", "srcrx(`Mutex`)"},
+			`import "sync"; var mu *sync.RWMutex`, 1,
+		},
+		{
+			[]string{"-x", "var $_ $x", "-x", "$x", "-a", "srcrx(`^Mutex$`)"},
+			`import "sync"; var mu sync.Mutex`, 0,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "srcrx(`, b\\)$`)"},
+			"foo(a, b); bar(b); baz(c, b)", 2,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "!srcrx(`x`)"},
+			"foo(a, x); bar(c)", 1,
+		},
+
+		// package name of the file
+		{
+			[]string{"-x", "f()", "-a", "pkgname(`main`)"},
+			"package main; func main() { f() }; func g() { f() }", 2,
+		},
+		{
+			[]string{"-x", "f()", "-a", "pkgname(`main`)"},
+			"package foo_test; func g() { f() }", 0,
+		},
+		{
+			[]string{"-x", "f()", "-a", "pkgname(`.*_test`)"},
+			"package foo_test; func g() { f() }", 1,
+		},
+		{
+			[]string{"-x", "f()", "-a", "!pkgname(`main`)"},
+			"func g() { f() }", 1,
+		},
+		{[]string{"-x", "f()", "-a", "!pkgname(`main`)"}, "f(); g()", 1},
+
+		// names of called funcs and methods
+		{
+			[]string{"-x", "$f($*_)", "-a", "callname(`New`)"},
+			`import "errors"; func New() {}; func f(x T) { errors.New(""); x.New(); New(); (New)(); x.NewT(); Renew() }`,
+			4,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "callname(`New.*`)"},
+			`func New[T any]() {}; func f(x T) { New[int](); x.NewT(); x.y.Old() }`,
+			2,
+		},
+		{
+			[]string{"-x", "$x", "-a", "callname(`New`)"},
+			`func f() { _ = New }`,
+			0,
+		},
+
+		// imports of the file
+		{
+			[]string{"-x", "f()", "-a", `imports("strings")`},
+			`import "strings"; var _ = strings.ToUpper; func g() { f() }`,
+			1,
+		},
+		{
+			[]string{"-x", "f()", "-a", `imports("strings")`},
+			`import str "strings"; var _ = str.ToUpper; func g() { f() }`,
+			1,
+		},
+		{
+			[]string{"-x", "f()", "-a", `imports("strings")`},
+			`import "strconv"; var _ = strconv.Itoa; func g() { f() }`,
+			0,
+		},
+		{
+			[]string{"-x", "f()", "-a", `!imports("strings")`},
+			`import ("bytes"; "strconv"); var _ = strconv.Itoa; var _ = bytes.ToUpper; func g() { f() }`,
+			1,
+		},
+		{[]string{"-x", "f()", "-a", `imports("strings")`}, "f(); g()", 0},
+
 		// type equality
 		{
 			[]string{"-x", "$x", "-a", "type(int)"},
@@ -184,6 +347,50 @@ func TestMatch(t *testing.T) {
 			[]string{"-x", "$x", "-a", "type(*I)"},
 			`type I int; var i *I`, 2,
 		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(any)"},
+			`var _ = interface{}(nil)`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(interface{})"},
+			`var _ = any(nil)`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(any)"},
+			`var _ = error(nil)`, 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(map[string]any)"},
+			`var _ = map[string]interface{}{}`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(interface{ M(...int) error })"},
+			`var _ = interface{ M(...int) error }(nil)`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(interface{ M() })"},
+			`var _ = interface{ N() }(nil)`, 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(interface{ error; M() })"},
+			`var _ = interface{ M(); Error() string }(nil)`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "asgn(interface{ String() string })"},
+			`type T int; func (T) String() string { return "" }; var _ = T(0)`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(Foo)"},
+			`type Foo = Bar; type Bar int; var x Bar; var _ = x`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(Bar)"},
+			`type Foo = Bar; type Bar int; var x Foo; var _ = x`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(Foo)"},
+			`type Foo = int; type Bar int; var x Bar; var _ = x`, 0,
+		},
 		// TODO
 		// {
 		// 	[]string{"-x", "$x", "-a", "type(chan int)"},
@@ -255,6 +462,32 @@ func TestMatch(t *testing.T) {
 			"type I int; var i I", 1,
 		},
 
+		// embedded fields
+		{
+			[]string{"-x", "struct{$*_}", "-a", "embeds(sync.Mutex)"},
+			`import "sync"; type T struct { sync.Mutex; n int }`, 1,
+		},
+		{
+			[]string{"-x", "struct{$*_}", "-a", "embeds(sync.Mutex)"},
+			`import "sync"; type T struct { mu sync.Mutex }`, 0,
+		},
+		{
+			[]string{"-x", "struct{$*_}", "-a", "embeds(sync.Mutex)"},
+			`import "sync"; type T struct { *sync.Mutex }`, 0,
+		},
+		{
+			[]string{"-x", "struct{$*_}", "-a", "embeds(*sync.Mutex)"},
+			`import "sync"; type T struct { *sync.Mutex }`, 1,
+		},
+		{
+			[]string{"-x", "struct{$*_}", "-a", "embeds(B)"},
+			`type B struct{}; type A struct { B }; type C struct { b B }`, 1,
+		},
+		{
+			[]string{"-x", "struct{$*_}", "-a", "!embeds(B)"},
+			`type B struct{}; type A struct { B }; type C struct { b B }`, 2,
+		},
+
 		// comparable types
 		{
 			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "comp"},
@@ -271,88 +504,1001 @@ func TestMatch(t *testing.T) {
 			"var _ = []byte{0}", 0,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "addr"},
-			"var s struct { i int }; var _ = s.i", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "addr"},
+			"var s struct { i int }; var _ = s.i", 1,
+		},
+
+		// naked returns
+		{
+			[]string{"-x", "return", "-a", "naked"},
+			"func f() (err error) { return }", 1,
+		},
+		{
+			[]string{"-x", "return", "-a", "naked"},
+			"func f() { return }", 0,
+		},
+		{
+			[]string{"-x", "return $*_", "-a", "naked"},
+			"func f() (err error) { return err }", 0,
+		},
+		{
+			[]string{"-x", "return", "-a", "!naked"},
+			"func f() { return }", 1,
+		},
+		{
+			[]string{"-x", "return", "-a", "naked"},
+			"func f() (n int) { g := func() { return }; g(); return }", 1,
+		},
+		{
+			[]string{"-x", "return", "-a", "naked"},
+			"func f() { g := func() (n int) { return }; g() }", 1,
+		},
+		{
+			[]string{"-x", "return", "-a", "naked"},
+			"foo(); return", 0,
+		},
+
+		// reachable code
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"func f() { foo(); return; foo() }", 1,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "!reach"},
+			"func f() { foo(); return; foo() }", 1,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"func f() { if x { return }; foo() }", 1,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"func f() { panic(1); if x { foo() } }", 0,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			`import "os"; func f() { os.Exit(1); foo() }`, 0,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"func f() { panic := func(int) {}; panic(1); foo() }", 1,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"func f() { switch { case x: return; foo(); default: foo() } }", 1,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"bar(); foo()", 1,
+		},
+		{
+			[]string{"-x", "foo()", "-a", "reach"},
+			"return; foo()", 0,
+		},
+
+		// values never read after being assigned
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { x, err := g(); println(x) }", 1,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { x, err := g(); if err != nil { return }; println(x) }", 0,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { _, err := g(); _, err = g(); println(err) }",
+			"err",
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() error { _, err := g(); return nil }", 1,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() (err error) { _, err = g(); return }", 0,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { if _, err := g(); err != nil {} }", 0,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { var err error; if true { _, err = g() }; println(err) }", 0,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { var err error; if true { _, err = g(); return }; println(err) }", 1,
+		},
+		{
+			[]string{"-x", "err", "-a", "unused"},
+			"func g() (int, error); func f() { _, err := g(); err = fmt(err); println(err) }", 0,
+		},
+		{
+			[]string{"-x", "$x, $err := g()", "-x", "$err", "-a", "unused", "-a", "asgn(error)"},
+			"func g() (int, error); func f() { x, err := g() }", 1,
+		},
+		{
+			[]string{"-x", "$x := $f($*_)", "-x", "$x", "-a", "unused"},
+			"func g() int; func f() int { a := g(); b := g(); c := g(); c = b; return c }", 2,
+		},
+		{
+			[]string{"-x", "$x := $f($*_)", "-x", "$x", "-a", "unused"},
+			"func g() int; func f() { a := g(); b := g(); defer func() { println(a) }(); b = 3 }", 1,
+		},
+		{
+			[]string{"-x", "$x = $f($*_)", "-x", "$x", "-a", "unused"},
+			"func g() int; func f() (a int) { a = g(); a = g(); return a }", "a",
+		},
+		{
+			[]string{"-x", "var $x = $f($*_)", "-x", "$x", "-a", "unused"},
+			"func g() int; func f() { var a = g(); var b = g(); println(b) }", "a",
+		},
+		{
+			[]string{"-x", "var $x = $f($*_)", "-x", "$x", "-a", "unused"},
+			"func g() int; var a = g()", 0,
+		},
+		{
+			[]string{"-x", "x", "-a", "unused"},
+			"func f() { var x int; x = 3 }", 1,
+		},
+		{
+			[]string{"-x", "$x, $err := g()", "-x", "$err", "-a", "unused", "-a", "asgn(error)"},
+			"func g() (error, int); func f() { x, err := g() }", 0,
+		},
+		{
+			[]string{"-x", "x", "-a", "unused"},
+			"x := 1; x = 2; println(x)", 1,
+		},
+
+		// method values and expressions
+		{
+			[]string{"-x", "$x.$m", "-a", "methodvalue"},
+			"type T int; func (T) less(i, j int) bool; func f(x T, g func(func(int, int) bool)) { g(x.less); x.less(1, 2) }",
+			"x.less",
+		},
+		{
+			[]string{"-x", "$x.$m", "-a", "methodexpr"},
+			"type T int; func (T) less(i, j int) bool; var _ = T.less; var _ = T(0).less",
+			"T.less",
+		},
+		{
+			[]string{"-x", "$x.$m", "-a", "methodvalue"},
+			"type T struct{ f func() }; func g(x T) { h(x.f) }",
+			0,
+		},
+		{
+			[]string{"-x", "sort.Slice($_, $f)", "-x", "$f", "-a", "methodvalue"},
+			`import "sort"; type T []int; func (T) less(i, j int) bool; func f(x T) { sort.Slice(x, x.less); sort.Slice(x, func(i, j int) bool { return false }) }`,
+			"x.less",
+		},
+
+		// the predeclared nil
+		{
+			[]string{"-x", "return $x", "-x", "$x", "-a", "nil"},
+			"type T struct{}; func f() error { var p *T; if p == nil { return nil }; return p }",
+			"nil",
+		},
+		{
+			[]string{"-x", "$x == nil", "-x", "$x", "-a", "nil"},
+			"func f(nil int) bool { return nil == nil }",
+			0,
+		},
+		{
+			[]string{"-x", "$f($x)", "-x", "$x", "-a", "!nil"},
+			"type T struct{}; func f(g func(*T)) { g(nil); g((*T)(nil)) }",
+			"(*T)(nil)",
+		},
+
+		// within deferred calls
+		{
+			[]string{"-x", "$x.Close()", "-a", "indefer"},
+			"func f() { defer rows.Close(); defer func() { f.Close() }(); g.Close() }",
+			2,
+		},
+		{
+			[]string{"-x", "$x.Close()", "-a", "!indefer"},
+			"func f() { defer rows.Close(); g.Close() }",
+			"g.Close()",
+		},
+		{
+			[]string{"-x", "use($v)", "-a", "indefer"},
+			"func f() { for _, v := range l { defer func() { use(v) }() }; use(x) }",
+			"use(v)",
+		},
+		{
+			[]string{"-x", "defer $f()", "-a", "indefer"},
+			"func f() { defer a() }",
+			1,
+		},
+		{
+			[]string{"-x", "a()", "-a", "indefer"},
+			"defer b(); a()",
+			0,
+		},
+
+		// within loops
+		{
+			[]string{"-x", "regexp.MustCompile($_)", "-a", "inloop"},
+			"func f() { for { regexp.MustCompile(a) }; for _, s := range l { if x { regexp.MustCompile(s) } }; regexp.MustCompile(b) }",
+			2,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "inloop"},
+			"func f() { for i := g(); h(i); j(i) {}; for range k() {} }",
+			2,
+		},
+		{
+			[]string{"-x", "query($_)", "-a", "!inloop"},
+			"func f() { for _, id := range ids { query(id) }; query(x) }",
+			"query(x)",
+		},
+		{[]string{"-x", "a()", "-a", "inloop"}, "for {}; a()", 0},
+
+		// pointer and value receivers
+		{
+			[]string{"-x", "$x.$m($*_)", "-a", "ptrrecv"},
+			"type T int; func (*T) set(); func (T) get() int; func f(x T) { x.set(); x.get() }",
+			"x.set()",
+		},
+		{
+			[]string{"-x", "$x.$m($*_)", "-a", "valrecv"},
+			"type T int; func (*T) set(); func (T) get() int; func f(x *T) { x.set(); x.get() }",
+			"x.get()",
+		},
+		{
+			[]string{"-x", "$x.$m($*_)", "-a", "valrecv"},
+			"type T struct{ f func() }; type S interface{ String() string }; func f(x T, s S) { x.f(); s.String() }",
+			0,
+		},
+		{
+			[]string{"-x", "$x.$m", "-a", "ptrrecv"},
+			"type T int; func (*T) set(); func f(x T) { _ = x.set; _ = (*T).set }",
+			2,
+		},
+
+		// func literals bound to variables
+		{
+			[]string{"-x", "$name := func($*_) $*_ { $*_ }"},
+			"f := func(a int) int { return a }; g := func() {}; k(func() {})", 2,
+		},
+		{
+			[]string{"-x", "$name := $f", "-x", "$f", "-a", "funclit"},
+			"f := func() {}; g := strings.TrimSpace; x := 3", "func() { }",
+		},
+		{
+			[]string{"-x", "$name := $f", "-x", "$f", "-a", "is(func)"},
+			`import "strings"; func h() { f := func() {}; g := strings.TrimSpace; x := 3 }`, 2,
+		},
+		{
+			[]string{"-x", "k($f)", "-x", "$f", "-a", "!funclit"},
+			"k(func() {}); k(g)", "g",
+		},
+
+		// errors formatted without being wrapped
+		{
+			[]string{"-x", "$f($*_)", "-a", "nowrap"},
+			`import "fmt"; func f(err error, s string) { _ = fmt.Errorf("a: %v", err); _ = fmt.Errorf("b: %w", err); _ = fmt.Errorf("c: %s", s); _ = fmt.Errorf("d: %s: %w", err, err) }`,
+			`fmt.Errorf("a: %v", err)`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "nowrap"},
+			`import "fmt"; func f(err error) { _ = fmt.Errorf("%d: %+v", 3, err); _ = fmt.Errorf("%v"); _ = fmt.Errorf("%[1]v", err) }`,
+			0,
+		},
+
+		// struct types wasting memory in padding
+		{
+			[]string{"-x", "type $T $_", "-a", "padded"},
+			`type A struct { a bool; b int64; c bool }; type B struct { b int64; a, c bool }; type C struct { a, b bool }`,
+			1,
+		},
+		{
+			[]string{"-x", "struct{ $*_ }", "-a", "padded"},
+			`type A struct { a bool; b int64; c bool }; type B struct { b int64; a, c bool }; type C struct { a, b bool }; var _ struct { a bool; b *int; c bool }`,
+			2,
+		},
+		{
+			[]string{"-x", "struct{ $*_ }", "-a", "padded"},
+			`type A struct { a int32; b struct{} }; type B struct { b struct{}; a int32 }; type C struct { a *int; b [0]int64; c string }`,
+			1, // a trailing zero-sized field adds padding
+		},
+		{
+			[]string{"-lang", "go1.21", "-x", "struct{ $*_ }", "-a", "padded"},
+			`type G[T any] struct { a bool; b T; c bool }; type H[T any] struct { a bool; b []T; c bool }; type P[T any] struct { a bool; b *G[T]; c bool }`,
+			0, // sizes depending on type parameters are unknown
+		},
+		{
+			[]string{"-lang", "go1.21", "-x", "struct{ $*_ }", "-a", "padded"},
+			`type G[T any] struct { a bool; b T }; var _ struct { a bool; b G[int64]; c bool }`,
+			1,
+		},
+
+		// duplicate case values in switches
+		{
+			[]string{"-x", "switch $*_ { $*_ }", "-a", "dupcase"},
+			`func f(x int, s string) { switch x { case 1, 2: case 3, 1: }; switch x { case 1: case 2: }; switch s { case "a": case "b", "a": } }`,
+			2,
+		},
+		{
+			[]string{"-x", "case $*_: $*_", "-a", "dupcase"},
+			`func f(x int) { switch x { case 1, 2: case 3, 1: case 1: } }`,
+			2, // the second and third clauses
+		},
+		{
+			[]string{"-x", "$x", "-a", "dupcase"},
+			`func f(x int) { switch x { case 1, 2: case 3, 1: } }`,
+			2, // the second clause and its 1
+		},
+		{
+			[]string{"-x", "case $*_: $*_", "-a", "dupcase"},
+			`const one = 1; func f(x int) { switch x { case one: case 2: case 3 - 2: } }`,
+			"case 3 - 2:", // same constant value
+		},
+		{
+			[]string{"-x", "case $*_: $*_", "-a", "dupcase"},
+			`func f(x, y, z int) { switch x { case y: case z: case y: } }`,
+			"case y:", // same source, as y isn't constant
+		},
+		{
+			[]string{"-x", "case $*_: $*_", "-a", "dupcase"},
+			`func f(x int) { switch x { case 1: x = 1; case 2: x = 1 } }`,
+			0, // case bodies are ignored
+		},
+		{
+			[]string{"-x", "switch $*_ { $*_ }", "-a", "dupcase"},
+			`func f(x int) { switch { case x == 1: case x == 2: } }`,
+			0,
+		},
+		{
+			[]string{"-x", "switch $*_ { $*_ }", "-a", "dupcase"},
+			`func f(x interface{}) { switch x.(type) { case int: case int: } }`,
+			0, // type switches are not supported
+		},
+
+		// empty branches
+		{[]string{"-x", "$x", "-a", "emptybranch"}, "{ if a {}; if a { b() }; if a { b() } else {}; if a { b() } else if c {} }", 3},
+		{[]string{"-x", "$x", "-a", "emptybranch"}, "{ if a { b() } else { c() }; if a { b() } else if c { d() } }", 0},
+		{[]string{"-x", "$x", "-a", "emptybranch"}, "{ for i := 0; i < n; i++ {}; for a {}; for {}; for { b() } }", 2},
+		{[]string{"-x", "$x", "-a", "emptybranch"}, "func f(s []int, c chan int) { for range s {}; for range c {}; for _, x := range s { g(x) } }", 1},
+		{[]string{"-x", "$x", "-a", "emptybranch"}, "{ switch {}; switch a {}; switch a { case b: }; select {}; select { default: } }", 3},
+		{[]string{"-x", "$x", "-a", "emptybranch"}, "func f(x interface{}) { switch x.(type) {}; switch x.(type) { case int: } }", 1},
+		{[]string{"-x", "if $_ { $*_ }", "-a", "!emptybranch"}, "{ if a {}; if a { b() } }", "if a { b(); }"},
+
+		// context.Context parameters other than the first
+		{
+			[]string{"-x", "func $f($*_) { $*_ }", "-a", "badctx", "-x", "$f"},
+			`import "context"; func a(ctx context.Context, n int) {}; func b(n int, ctx context.Context) {}; func c(x, ctx context.Context) {}; func d(int, context.Context) {}; func (T) e(ctx context.Context) {}; func f() {}`,
+			3, // b, c and d
+		},
+		{
+			[]string{"-x", "func($*_) { $*_ }", "-a", "badctx"},
+			`import "context"; var _ = func(s string, ctx context.Context) {}; var _ = func(ctx context.Context, s string) {}`,
+			"func(s string, ctx context.Context) { }",
+		},
+
+		// panics with values other than errors and strings
+		{
+			[]string{"-x", "panic($_)", "-a", "badpanic"},
+			`import "errors"; type msg string; func f(err error) { panic(err); panic("msg"); panic(msg("x")); panic(errors.New("x")); panic(123) }`,
+			"panic(123)",
+		},
+		{
+			[]string{"-x", "panic($_)", "-a", "badpanic"},
+			`type E struct{}; func (*E) Error() string { return "" }; func f() { panic(&E{}); panic(E{}); panic(nil) }`,
+			2,
+		},
+		{
+			[]string{"-x", "panic($_)", "-a", "badpanic"},
+			`func panic(int) {}; func f() { panic(123) }`,
+			0,
+		},
+
+		// printf calls with the wrong number of arguments
+		{
+			[]string{"-x", "$f($*_)", "-a", "badprintf"},
+			`import "fmt"; func f() { fmt.Printf("%d %s\n", 1, "a"); fmt.Printf("%d %s\n", 1) }`,
+			`fmt.Printf("%d %s\n", 1)`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "badprintf"},
+			`import "fmt"; func f() { _ = fmt.Sprintf("100%% %v", 1, 2); _ = fmt.Sprintf("100%%") }`,
+			`fmt.Sprintf("100%% %v", 1, 2)`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "badprintf"},
+			`import ("fmt"; "os"); func f() { fmt.Fprintf(os.Stdout, "%*d", 3, 4); fmt.Fprintf(os.Stdout, "%-8.*f", 2) }`,
+			`fmt.Fprintf(os.Stdout, "%-8.*f", 2)`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "badprintf"},
+			`import "fmt"; const format = "%v"; func f(s string, a []interface{}) { _ = fmt.Errorf(format); _ = fmt.Errorf(s); _ = fmt.Errorf("%[1]v %[1]v", 1); _ = fmt.Errorf(format, a...) }`,
+			`fmt.Errorf(format)`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "badprintf"},
+			`import "fmt"; func Printf(string, ...interface{}) {}; func f() { Printf("%d"); fmt.Println("%d") }`,
+			0,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "badprintf"},
+			`import "fmt"; func f() { var x int; fmt.Sscanf("1", "%d %d", &x) }`,
+			0,
+		},
+
+		// constant durations
+		{
+			[]string{"-x", "time.Sleep($d)", "-x", "$d", "-a", `mindur("1s")`},
+			`import "time"; func f() { time.Sleep(5 * time.Second); time.Sleep(10 * time.Millisecond) }`,
+			"5 * time.Second",
+		},
+		{
+			[]string{"-x", "time.Sleep($d)", "-x", "$d", "-a", `maxdur("1s")`},
+			`import "time"; func f() { time.Sleep(5 * time.Second); time.Sleep(10 * time.Millisecond) }`,
+			"10 * time.Millisecond",
+		},
+		{
+			[]string{"-x", "time.Sleep($d)", "-x", "$d", "-a", `mindur("1m")`},
+			`import "time"; const timeout = 2 * time.Minute; func f(d time.Duration) { time.Sleep(timeout / 2); time.Sleep(timeout / 4); time.Sleep(d) }`,
+			"timeout / 2",
+		},
+		{
+			[]string{"-x", "$d", "-a", `mindur("1ns")`},
+			`var _ = []interface{}{3, 3 * 1e9}`,
+			0,
+		},
+		{
+			[]string{"-x", "time.Sleep($d)", "-x", "$d", "-a", `!maxdur("100ms")`},
+			`import "time"; func f() { time.Sleep(time.Second); time.Sleep(time.Second / 10) }`,
+			"time.Second",
+		},
+
+		// expressions used as map keys or indexes
+		{
+			[]string{"-x", "k", "-a", "mapkey"},
+			"var m map[string]int; var k string; var _ = m[k]", "k",
+		},
+		{
+			[]string{"-x", "k", "-a", "mapkey"},
+			"var m map[string]string; var k string; var _ = m[m[k]]; var _ = k", 1,
+		},
+		{
+			[]string{"-x", "$x", "-a", "mapkey"},
+			"var m map[string]string; var k string; var _ = m[m[k]]", 2,
+		},
+		{
+			[]string{"-x", "i", "-a", "mapkey"},
+			"var s []int; var i int; var _ = s[i]", 0,
+		},
+		{
+			[]string{"-x", "i", "-a", "index"},
+			"var s []int; var a *[3]int; var i int; var _ = s[i] + a[i] + i", 2,
+		},
+		{
+			[]string{"-x", "i", "-a", "index"},
+			`var m map[int]int; var i int; var _ = "foo"[i] + byte(m[i])`, 1,
+		},
+
+		// argument counts
+		{[]string{"-x", "$f($*_)", "-a", "args(2)"}, "a(b(), c(1, 2), d(3))", "c(1, 2)"},
+		{[]string{"-x", "$f($*_)", "-a", "args(0)"}, "a(b(), c(1, 2), d(3))", "b()"},
+		{[]string{"-x", "$f($*_)", "-a", "minargs(1)"}, "a(b(), c(1, 2), d(3))", 3},
+		{[]string{"-x", "$f($*_)", "-a", "maxargs(1)"}, "a(b(), c(1, 2), d(3))", 2},
+		{[]string{"-x", "$f($*_)", "-a", "!args(1)"}, "a(b(), c(1, 2), d(3))", 3},
+		{[]string{"-x", "$f($*_...)", "-a", "args(2)"}, "append(a, b...)", 1},
+		{[]string{"-x", "$x", "-a", "args(0)"}, "foo", 0},
+		{[]string{"-x", "$f($*_)", "-a", "args(1)"}, "{ foo(a) }", 1},
+
+		// types of two wildcards
+		{[]string{"-x", "$x == $y", "-a", "difftype($x, $y)"}, "var a int; var b interface{}; var _ = a == b; var _ = a == a", "a == b"},
+		{[]string{"-x", "$T($x)", "-a", "difftype($T, $x)"}, "type N int; var a N; var _ = int(a)", "int(a)"},
+		{[]string{"-x", "$x == $y", "-a", "difftype($x, $y)"}, "var _ = a == b", 0},               // unknown types
+		{[]string{"-x", "$x == $y", "-a", "difftype($x, $z)"}, "var a, b int; var _ = a == b", 0}, // unbound
+
+		// assignment operand counts
+		{[]string{"-x", "$x", "-a", "arity(1, 1)"}, "{ a = b; a, b = c, d; a, b := f() }", "a = b"},
+		{[]string{"-x", "$x", "-a", "arity(2, 2)"}, "{ a = b; a, b = c, d; a, b := f() }", "a, b = c, d"},
+		{[]string{"-x", "$x", "-a", "arity(2, 1)"}, "{ a = b; a, b = c, d; a, b := f() }", "a, b := f()"},
+		{[]string{"-x", "$*_ = $*_", "-a", "!arity(1, 1)"}, "{ a = b; a, b = c, d }", "a, b = c, d"},
+		{[]string{"-x", "$x", "-a", "arity(1, 2)"}, "{ a = b; a, b = c, d; a, b := f() }", 0},
+
+		// keyed and positional composite literals
+		{[]string{"-x", "$_{$*_}", "-a", "keyed"}, "T{A: 1, B: 2}", 1},
+		{[]string{"-x", "$_{$*_}", "-a", "keyed"}, "T{1, 2}", 0},
+		{[]string{"-x", "$_{$*_}", "-a", "keyed"}, "T{A: 1, 2}", 0},
+		{[]string{"-x", "$_{$*_}", "-a", "keyed"}, "T{}", 1},
+		{[]string{"-x", "$_{$*_}", "-a", "positional"}, "T{A: 1, B: 2}", 0},
+		{[]string{"-x", "$_{$*_}", "-a", "positional"}, "T{1, 2}", 1},
+		{[]string{"-x", "$_{$*_}", "-a", "positional"}, "T{A: 1, 2}", 0},
+		{[]string{"-x", "$_{$*_}", "-a", "!keyed"}, "a(T{A: 1}, T{1}, T{A: 1, 2})", 2},
+		{[]string{"-x", "$x", "-a", "keyed"}, "foo", 0},
+
+		// negative numeric constants, including "-5" as a unary expression
+		{
+			[]string{"-x", "f($x)", "-x", "$x", "-a", "neg"},
+			"func f(float64) {}; const c = 3; func g(v float64) { f(-5); f(+5); f(5); f(-c); f(-1.5); f(-v); f(-(-2)) }",
+			3, // -5, -c and -1.5
+		},
+		{
+			[]string{"-x", "f($x)", "-x", "$x", "-a", "!neg"},
+			"func f(float64) {}; func g(v float64) { f(-5); f(+5); f(5); f(-v) }",
+			3, // +5, 5 and -v
+		},
+		{
+			[]string{"-x", "-$x", "-x", "$x", "-a", "is(basic)"},
+			"var _ = -5",
+			"5",
+		},
+
+		// boolean constants, such as tautological conditions
+		{
+			[]string{"-x", "if $x { $*_ }", "-a", "alwaystrue"},
+			"const debug = false; func f(a int) { if 1 == 1 {}; if a == 1 {}; if !debug {}; if debug {} }",
+			0, // the if statements themselves aren't expressions
+		},
+		{
+			[]string{"-x", "if $x { $*_ }", "-x", "$x", "-a", "alwaystrue"},
+			"const debug = false; func f(a int) { if 1 == 1 {}; if a == 1 {}; if !debug {}; if debug {} }",
+			2, // 1 == 1 and !debug
+		},
+		{
+			[]string{"-x", "for $x { $*_ }", "-x", "$x", "-a", "alwaystrue"},
+			"func f(a bool) { for true {}; for a {}; for {} }",
+			"true",
+		},
+		{
+			[]string{"-x", "$x", "-a", "alwaysfalse"},
+			`const debug = false; func f(a bool) { if debug || a {}; if "a" == "b" {} }`,
+			2, // debug and "a" == "b"
+		},
+		{
+			[]string{"-x", "$x", "-a", "alwaysfalse"},
+			"func f(a bool) { if a && !a {} }",
+			0,
+		},
+
+		// local variables written to after their declaration
+		{
+			[]string{"-x", "var $x = $_", "-x", "$x", "-a", "readonly"},
+			"func f() { var a = 1; var b = 2; b++; println(a, b) }",
+			"a",
+		},
+		{
+			[]string{"-x", "var $x = $_", "-x", "$x", "-a", "written"},
+			"func f() { var a = 1; var b = 2; b++; println(a, b) }",
+			"b",
+		},
+		{
+			[]string{"-x", "$x := $_", "-x", "$x", "-a", "written"},
+			"func f() { a := 1; c := 3; p := &c; d := 4; a = 4; _, _ = p, d }",
+			2, // a and c
+		},
+		{
+			[]string{"-x", "$x := $_", "-x", "$x", "-a", "written"},
+			"func f() { a := 1; func() { a = 2 }() }",
+			"a",
+		},
+		{
+			[]string{"-x", "for $k := range $_ {}", "-x", "$k", "-a", "readonly"},
+			"func f(s []int) { var j int; for i := range s {}; for j = range s {}; _ = j }",
+			"i",
+		},
+		{
+			[]string{"-x", "var $x int", "-x", "$x", "-a", "written"},
+			"func f(s []int) { var j int; for i := range s {}; for j = range s {}; _ = j }",
+			"j",
+		},
+		{
+			[]string{"-x", "$x := 1", "-x", "$x", "-a", "written"},
+			"a := 1; b := 1; a = 2",
+			"a",
+		},
+		{
+			[]string{"-x", "var $x = $_", "-x", "$x", "-a", "readonly"},
+			"var a = 1; var b = 2",
+			0,
+		},
+
+		// exported struct fields and field accesses
+		{
+			[]string{"-x", "$x", "-a", "exportedfield"},
+			`import "bytes"; type T struct { A int; b int; C, d int; E, F string; *bytes.Buffer; t *T }`,
+			3, // A, E and F, and *bytes.Buffer
+		},
+		{
+			[]string{"-x", "$x", "-a", "exportedfield"},
+			`type T struct { A, b int }; func (T) M() {}; func f(t T, A int) { println(t.A, t.b, t.M, A) }`,
+			"t.A",
+		},
+
+		// package-level declarations
+		{
+			[]string{"-x", "var $_ $_", "-a", "toplevel"},
+			"var a int; func f() { var b int }",
+			"var a int",
+		},
+		{
+			[]string{"-x", "var $_ $_", "-a", "!toplevel"},
+			"var a int; func f() { var b int }",
+			"var b int",
+		},
+		{
+			[]string{"-x", "$_ sync.Mutex", "-a", "toplevel"},
+			`import "sync"; var (a sync.Mutex; b int); func f() { var c sync.Mutex }`,
+			"a sync.Mutex",
+		},
+		{
+			[]string{"-x", "func $_() { $*_ }", "-a", "toplevel"},
+			"func f() { _ = func() {} }; func g()",
+			1,
+		},
+		{
+			[]string{"-x", "x", "-a", "toplevel"},
+			"var x int; var y = x",
+			0,
+		},
+
+		// fields selected through a pointer
+		{
+			[]string{"-x", "$x.$_ = $_", "-a", "ptrbase"},
+			"type T struct{ f int }; func f(p *T, v T) { p.f = 1; v.f = 2 }",
+			"p.f = 1",
+		},
+		{
+			[]string{"-x", "$x.$_ = $_", "-a", "!ptrbase"},
+			"type T struct{ f int }; func f(p *T, v T) { p.f = 1; v.f = 2 }",
+			"v.f = 2",
+		},
+		{
+			[]string{"-x", "$x.$_ = $_", "-a", "ptrbase"},
+			"type T struct{ f int }; type U struct{ t *T; u T }; func f(v U) { v.t.f = 1; v.u.f = 2 }",
+			"v.t.f = 1",
+		},
+		{
+			[]string{"-x", "$x.$_ = $_", "-a", "ptrbase"},
+			"type T struct{ f int }; type U struct{ *T }; func f(v U) { v.f = 1 }",
+			1,
+		},
+		{
+			[]string{"-x", "$_, $_ = $_, $_", "-a", "ptrbase"},
+			"type T struct{ f int }; func f(p *T, v T) { v.f, p.f = 1, 2 }",
+			1,
+		},
+		{
+			[]string{"-x", "$x.$_", "-a", "ptrbase"},
+			"type T struct{ f int }; func (*T) m() {}; func f(p *T) { p.m(); _ = p.f }",
+			"p.f",
+		},
+
+		// positional literals of struct types from other packages
+		{
+			[]string{"-x", "$_{$*_}", "-a", "unkeyed"},
+			`import "image"; type T struct{ A, B int }; var _ = image.Point{1, 2}; var _ = T{1, 2}`,
+			"image.Point{1, 2}",
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-a", "unkeyed"},
+			`import "image"; var _ = image.Point{X: 1, Y: 2}; var _ = image.Point{}`,
+			0,
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-a", "unkeyed"},
+			`import "image"; var _ = []int{1, 2}; var _ = image.Rectangle{image.Point{X: 1}, image.Point{Y: 2}}`,
+			"image.Rectangle{image.Point{X: 1}, image.Point{Y: 2}}",
+		},
+		{
+			[]string{"-x", "[]*image.Point{$x}", "-x", "$x", "-a", "unkeyed"},
+			`import "image"; var _ = []*image.Point{{1, 2}}`,
+			"{1, 2}",
+		},
+
+		// goroutines being started
+		{
+			[]string{"-x", "$_", "-a", "spawn"},
+			`import "sync"; func f(wg *sync.WaitGroup, g func()) { go g(); go func() {}(); wg.Go(g); wg.Wait(); g() }`,
+			3,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "spawn"},
+			`import "sync"; func f(g func()) { var wg sync.WaitGroup; wg.Go(g); wg.Wait() }`,
+			"wg.Go(g)",
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "spawn"},
+			`type Group struct{}; func (Group) Go(func()) {}; func f(g Group) { g.Go(nil) }`,
+			0,
+		},
+
+		// loops by their form
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "loop(infinite)"},
+			"func f(s []int) { for {}; for x {}; for i := 0; i < 3; i++ {}; for range s {} }",
+			"for { }",
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "loop(cond)"},
+			"func f(s []int) { for {}; for x {}; for i := 0; i < 3; i++ {}; for range s {} }",
+			"for x { }",
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "loop(clause)"},
+			"func f(s []int) { for {}; for x {}; for i := 0; i < 3; i++ {}; for ; ; x++ {}; for range s {} }",
+			2,
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "loop(range)"},
+			"func f(s []int) { for {}; for x {}; for i := 0; i < 3; i++ {}; for range s {} }",
+			"for range s { }",
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "!loop(range)"},
+			"func f(s []int) { for {}; for x {}; for i := 0; i < 3; i++ {}; for range s {} }",
+			3,
+		},
+
+		// range statements by the kind of type iterated over
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "range(map)"},
+			"func f(m map[int]string, s []int) { for range m {}; for range s {}; for {} }",
+			"for range m { }",
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "range(slice)"},
+			"func f(m map[int]string, s []int) { for range m {}; for range s {}; for {} }",
+			"for range s { }",
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "range(array)"},
+			"func f(a [3]int) { for i := range a { _ = i } }",
+			1,
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "range(chan)"},
+			"func f(c chan int) { for range c {} }",
+			1,
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "range(basic)"},
+			`func f() { for range "foo" {} }`,
+			1,
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "range(pointer)"},
+			"func f(a *[3]int) { for range a {} }",
+			1,
+		},
+		{
+			[]string{"-x", "for $*_ { $*_ }", "-a", "!range(map)"},
+			"type M map[int]int; func f(m M, s []int) { for range m {}; for range s {} }",
+			"for range s { }",
+		},
+		{
+			[]string{"-x", "$x", "-a", "range(map)"},
+			"var m map[int]int",
+			0,
+		},
+
+		// underlying types
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(basic)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(basic)"},
+			"var _ = 3", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(basic)"},
+			`import "io"; var _ = io.SeekEnd`, 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(array)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(array)"},
+			"var _ = [...]byte{}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(slice)"},
+			"var _ = []byte{}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(slice)"},
+			"var _ = [...]byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(struct)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(struct)"},
+			"var _ = struct{}{}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(interface)"},
+			"var _ = struct{}{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(interface)"},
+			"var _ = interface{}(nil)", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(pointer)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(pointer)"},
+			"var _ = new(byte)", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(func)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(func)"},
+			"var _ = func() {}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(map)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(map)"},
+			"var _ = map[int]int{}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(chan)"},
+			"var _ = []byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(chan)"},
+			"var _ = make(chan int)", 1,
+		},
+
+		// package qualifiers
+		{
+			[]string{"-x", "$pkg.$_", "-x", "$pkg", "-a", `pkg("fmt")`},
+			`import "fmt"; var _ = fmt.Println`, 1,
+		},
+		{
+			[]string{"-x", "$pkg.$_", "-x", "$pkg", "-a", `pkg("fmt")`},
+			`import f "fmt"; var _ = f.Println`, 1,
+		},
+		{
+			[]string{"-x", "$pkg.$_", "-x", "$pkg", "-a", `pkg("fmt")`},
+			`import "os"; var _ = os.Exit`, 0,
+		},
+		{
+			[]string{"-x", "$pkg.$_", "-x", "$pkg", "-a", `pkg("path/filepath")`},
+			`import "path/filepath"; var _ = filepath.Join`, 1,
+		},
+		{
+			[]string{"-x", "$x.$_", "-x", "$x", "-a", `pkg("fmt")`},
+			`var fmt struct{ Println int }; var _ = fmt.Println`, 0,
+		},
+
+		// dot-imported identifiers
+		{
+			[]string{"-x", "$f($*_)", "-x", "$f", "-a", `dot("strings")`},
+			`import . "strings"; var _ = ToUpper("foo")`, 1,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-x", "$f", "-a", `dot("strings")`},
+			`import "strings"; var _ = strings.ToUpper("foo")`, 0,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-x", "$f", "-a", `dot("strings")`},
+			`import . "strings"; func Lower(string) string; var _ = Lower("foo")`, 0,
 		},
-
-		// underlying types
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(basic)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", `dot("strings")`},
+			`import . "strings"; var _ = ToUpper; var _ = len`, 1,
 		},
+
+		// types from a package
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(basic)"},
-			"var _ = 3", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", `typepkg("database/sql")`},
+			`import "database/sql"; var db *sql.DB; var _ = db`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(basic)"},
-			`import "io"; var _ = io.SeekEnd`, 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", `typepkg("database/sql")`},
+			`import "database/sql"; var _ = []sql.NullString{}`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(array)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", `typepkg("database/sql")`},
+			`import "database/sql"; var _ = sql.ErrNoRows`, 0,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(array)"},
-			"var _ = [...]byte{}", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", `typepkg("database/sql")`},
+			`type T int; var _ = T(0)`, 0,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(slice)"},
-			"var _ = []byte{}", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", `!typepkg("database/sql")`},
+			`var _ = 3`, 1,
 		},
+
+		// index assignments
+		{[]string{"-x", "$m[$k] = $v"}, "a = b; m[k] = v; s[0] = 1", 2},
+		{[]string{"-x", "$m[$k] = $v"}, "m.k = v; m = v", 0},
+		{[]string{"-x", "$m[$k] += $v"}, "m[k] = v; m[k] += v", 1},
+
+		// compound assignment operators
+		{[]string{"-x", "$x += $y"}, "a = 1; a += 1; a -= 1", "a += 1"},
+		{[]string{"-x", "$x $op= $y"}, "a = 1; a += 1; a <<= 2; a &^= b; a := 2; a++", 3},
+		{[]string{"-x", "$x $op= $y", "-x", "$x"}, "m[k] *= 2", "m[k]"},
+		{[]string{"-x", "$x $op= $y; $x $op= $y"}, "a += 1; a += 1", 1},
+		{[]string{"-x", "$x $op= $y; $x $op= $y"}, "a += 1; a -= 1", 0},
+		{[]string{"-x", "$x $_= 1"}, "a |= 1; b ^= 1; c = 1", 2},
+		{[]string{"-x", "$x, $y = $z"}, "a, b = f(); a += 1", 1},
+		{[]string{"-x", "$x= $y"}, "a = 1; a += 1", 1},
+		{[]string{"-x", "$x $op= $y", "-s", "$x $op= -$y", "-w"}, "{ a += 1; b <<= c }", "{ a += -1; b <<= -c; }"},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(slice)"},
-			"var _ = [...]byte{}", 0,
+			[]string{"-x", "$m[$_] = $_", "-x", "$m", "-a", "is(map)"},
+			"var m map[int]int; var s []int; func f() { m[1] = 2; s[0] = 1 }", 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(struct)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "$m[$_] = $_", "-x", "$m", "-a", "is(slice)"},
+			"var m map[int]int; var s []int; func f() { m[1] = 2; s[0] = 1 }", 1,
 		},
+
+		// method sets
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(struct)"},
-			"var _ = struct{}{}", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Close)"},
+			`import "os"; var _ = os.Stdout`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(interface)"},
-			"var _ = struct{}{}", 0,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Close)"},
+			`import "io"; var _ io.ReadCloser; var _ = io.Reader(nil)`, 0,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(interface)"},
-			"var _ = interface{}(nil)", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Close)"},
+			`import "io"; var _ = io.ReadCloser(nil)`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(pointer)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Close)"},
+			`type T struct{}; func (*T) Close() {}; var _ = T{}`, 0,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(pointer)"},
-			"var _ = new(byte)", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Close)"},
+			`type T struct{}; func (*T) Close() {}; var t T; var _ = t`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(func)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Close)"},
+			`type T struct{}; func (T) Close() {}; var _ = &T{}`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(func)"},
-			"var _ = func() {}", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Read)"},
+			`import "io"; type RC interface { io.Reader; Close() error }; var rc RC; var _ = rc`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(map)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "interface{ $*_ }", "-a", "method(Read)"},
+			`import "io"; type RC interface { io.Reader; Close() error }`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(map)"},
-			"var _ = map[int]int{}", 1,
+			[]string{"-x", "interface{ $*_ }", "-a", "method(Write)"},
+			`import "io"; type RC interface { io.Reader; Close() error }`, 0,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(chan)"},
-			"var _ = []byte{}", 0,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "method(Read)"},
+			`import "io"; type RC interface { io.Reader; Close() error }; type S struct{ RC }; var s S; var _ = s`, 1,
 		},
 		{
-			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "is(chan)"},
-			"var _ = make(chan int)", 1,
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "!method(Close)"},
+			`var _ = 3`, 1,
 		},
 
+		// optional nodes
+		{[]string{"-x", "$x[$lo:$hi]"}, "a[1:]; b[1:2]", 1},
+		{[]string{"-x", "$x[$lo:$?hi]"}, "a[1:]; b[1:2]; c[:2]", 2},
+		{[]string{"-x", "$x[$?lo:$?hi]"}, "a[:]; b[1:2]; c[:2]", 3},
+		{[]string{"-x", "foo($a, $?b)"}, "foo(); foo(1); foo(1, 2); foo(1, 2, 3)", 2},
+		{[]string{"-x", "foo($?a, 2)"}, "foo(2); foo(1, 2); foo(1, 1, 2)", 2},
+		{[]string{"-x", "if $?init; $c {}"}, "if a {}; if b := f(); b {}", 2},
+		{[]string{"-x", "for $?i; $c; $?p {}"}, "for i < n {}; for i := 0; i < n; i++ {}", 2},
+		{[]string{"-x", "$x[$lo:$?hi]", "-s", "$x[$lo+1:$hi]", "-w"}, "a[1:]", "a[1+1:]"},
+		{[]string{"-x", "$x[$lo:$?hi]", "-s", "$x[$lo+1:$hi]", "-w"}, "a[1:2]", "a[1+1 : 2]"},
+
 		// many value expressions
 		{[]string{"-x", "$x, $y"}, "foo(1, 2)", 1},
 		{[]string{"-x", "$x, $y"}, "1", 0},
@@ -397,6 +1543,26 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "struct{field $t}"}, "struct{other int}", 0},
 		{[]string{"-x", "struct{field $t}"}, "struct{f1, f2 int}", 0},
 		{[]string{"-x", "interface{$x() int}"}, "interface{i() int}", 1},
+		{[]string{"-x", "interface{ $name($*params) $*results }"}, "type I interface{ Close() error }", 1},
+		{[]string{"-x", "interface{ $name($*params) $*results }"}, "type I interface{ Read([]byte) (int, error); Close() error }", 0},
+		{[]string{"-x", "interface{ $*_; Close() $*_; $*_ }"}, "type I interface{ Read([]byte) (int, error); Close() error }; type J interface{ Open() }", 1},
+		{[]string{"-x", "interface{ $*_; $_() error; $*_ }"}, "type I interface{ Read([]byte) (int, error); Close() error }", 1},
+		{[]string{"-x", "interface{ $*_ }"}, "type I interface{}; type J interface{ Close() error }", 2},
+		{
+			[]string{"-x", "interface{ $*_; $name($*_) $*_; $*_ }", "-x", "$name", "-a", "rx(`Close`)"},
+			"type I interface{ Close() error }; type J interface{ Open() }",
+			"Close",
+		},
+		{
+			[]string{"-x", "interface{ $_($*_) $*results }", "-x", "$*results"},
+			"type I interface{ Read([]byte) (n int, err error) }",
+			"n int, err error",
+		},
+		{
+			[]string{"-x", "func $_($*params) $*_ { $*_ }", "-x", "$*params"},
+			"func f(a, b int, c string) {}",
+			"a, b int, c string",
+		},
 		{[]string{"-x", "chan $x"}, "chan bool", 1},
 		{[]string{"-x", "<-chan $x"}, "chan bool", 0},
 		{[]string{"-x", "chan $x"}, "chan<- bool", 0},
@@ -442,11 +1608,33 @@ func TestMatch(t *testing.T) {
 
 		// type asserts
 		{[]string{"-x", "$x.(string)"}, "a.(string)", 1},
+		{[]string{"-x", "$x.($T)", "-x", "$T"}, "a.(string)", "string"},
+		{[]string{"-x", "$x.($T)"}, "switch a.(type) {}", 0},
+		{[]string{"-x", "$x.($T)"}, "a.(T); b.(*T); c.(type)", 2},
+		{[]string{"-x", "$x.($T); $y.($T)"}, "a.(T); b.(T)", 1},
+		{[]string{"-x", "$x.($T); $y.($T)"}, "a.(T); b.(U)", 0},
+		{
+			[]string{"-x", "$x.($T)", "-x", "$T", "-a", `typepkg("os")`},
+			`import "os"; var v interface{}; var _ = v.(*os.File); var _ = v.(string)`,
+			"*os.File",
+		},
+		{
+			[]string{"-x", "$x.($T)", "-x", "$T", "-a", "is(basic)"},
+			`import "os"; var v interface{}; var _ = v.(*os.File); var _ = v.(string)`,
+			"string",
+		},
 
 		// elipsis
 		{[]string{"-x", "append($x, $y...)"}, "append(a, bs...)", 1},
 		{[]string{"-x", "foo($x...)"}, "foo(a)", 0},
 		{[]string{"-x", "foo($x...)"}, "foo(a, b)", 0},
+		{[]string{"-x", "foo($*_...)"}, "foo(a...)", 1},
+		{[]string{"-x", "foo($*_...)"}, "foo(a, b...)", 1},
+		{[]string{"-x", "foo($*_...)"}, "foo(a, b)", 0},
+		{[]string{"-x", "foo($*_)"}, "foo(a, b...)", 0},
+		{[]string{"-x", "$f($*_...)"}, "foo(a); bar(b...)", 1},
+		{[]string{"-x", "foo($*a...)"}, "foo(a, b...)", "foo(a, b...)"},
+		{[]string{"-x", "foo($*a...)", "-s", "bar($a...)"}, "foo(a, b...)", "bar(a, b...)"},
 
 		// forcing node to be a statement
 		{[]string{"-x", "append($*_);"}, "f(); x = append(x, a)", 0},
@@ -478,6 +1666,10 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "$*x"}, "a; b", "a; b"},
 		{[]string{"-x", "$*x; b; $*y"}, "a; b; c", 1},
 		{[]string{"-x", "$*x; b; $*x"}, "a; b; c", 0},
+		{[]string{"-x", "f($*args)", "-x", "$args"}, "f(a, b, c); f(d)", 4},
+		{[]string{"-x", "f($*args)", "-x", "$*args"}, "f(a, b, c); f(d)", 2},
+		{[]string{"-x", "f($x)", "-x", "$x"}, "f(a, b, c); f(d)", "d"},
+		{[]string{"-x", "{ f($*args); $*_ }", "-g", "g($args)"}, "{ f(a, b); g(b) }; { f(a); g(c) }", 1},
 
 		// const/var declarations
 		{[]string{"-x", "const $x = $y"}, "const a = b", 1},
@@ -525,6 +1717,56 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "$_ int"}, "var a, b int", 0},
 		{[]string{"-x", "$_ int"}, "func(i int) { println(i) }", 0},
 
+		// import specs
+		{
+			[]string{"-x", `import _ "embed"`},
+			`package p; import (_ "embed"; x "os"; "fmt")`,
+			`_ "embed"`,
+		},
+		{
+			[]string{"-x", "import _ $_"},
+			`package p; import (_ "embed"; x "os"; "fmt"); import _ "unsafe"`,
+			2,
+		},
+		{
+			[]string{"-x", "import $x"},
+			`package p; import (_ "embed"; x "os"; "fmt")`,
+			`"fmt"`,
+		},
+		{
+			[]string{"-x", "import $n $x"},
+			`package p; import (_ "embed"; x "os"; "fmt")`,
+			2,
+		},
+		{
+			[]string{"-x", "import (_ $x; _ $x)"},
+			`package p; import (_ "embed"; _ "embed")`,
+			1,
+		},
+		{
+			[]string{"-x", "import _ $x", "-a", "srcrx(`pprof`)"},
+			`package p; import (_ "embed"; _ "net/http/pprof")`,
+			`_ "net/http/pprof"`,
+		},
+
+		// type parameters, type-checked with a language version
+		{
+			[]string{"-lang", "go1.21", "-x", "func $f[$t any]($*_) { $*_ }"},
+			"func f[T any](x T) {}", 1,
+		},
+		{
+			[]string{"-lang", "go1.22rc1", "-x", "func $f[$t any]($*_) { $*_ }"},
+			"func f[T any](x T) {}", 1,
+		},
+		{
+			[]string{"-lang", "go1.21", "-x", "$f[int]($*_)", "-a", "type(int)"},
+			"func f[T any](x T) T { return x }; var _ = f[int](3)", 1,
+		},
+		{
+			[]string{"-lang", "go1.21", "-x", "$f[int]($*_)", "-a", "type(string)"},
+			"func f[T any](x T) T { return x }; var _ = f[int](3)", 0,
+		},
+
 		// entire files
 		{[]string{"-x", "package $_"}, "package p; var a = 1", 0},
 		{[]string{"-x", "package $_; func Foo() { $*_ }"}, "package p; func Foo() {}", 1},
@@ -534,6 +1776,18 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "{ $x }"}, "{ a(); b() }", 0},
 		{[]string{"-x", "{}"}, "func f() {}", 1},
 
+		// node kind hints
+		{[]string{"-x", "{}"}, "var _ = [][]int{{}}", 0},
+		{[]string{"-x", "expr: {}"}, "var _ = [][]int{{}}", 1},
+		{[]string{"-x", "stmt: {}"}, "var _ = [][]int{{}}", 0},
+		{[]string{"-x", "f()"}, "f(); g(f())", 2},
+		{[]string{"-x", "stmt: f()"}, "f(); g(f())", 1},
+		{[]string{"-x", "expr: f()"}, "f(); g(f())", 2},
+		{[]string{"-x", "stmt:\t$x := $y"}, "a := b", 1},
+		{[]string{"-x", "type: chan $_"}, "var c chan int", 1},
+		{[]string{"-x", "decl: var $x int"}, "var a int", 1},
+		{[]string{"-x", "expr := $y"}, "expr := 1", 1},
+
 		// assigns
 		{[]string{"-x", "$x = $y"}, "a = b", 1},
 		{[]string{"-x", "$x := $y"}, "a, b := c()", 0},
@@ -587,6 +1841,29 @@ func TestMatch(t *testing.T) {
 		// $*_ matching optional statements (ifs)
 		{[]string{"-x", "if $*_; b {}"}, "if b {}", 1},
 		{[]string{"-x", "if $*_; b {}"}, "if a := f(); b {}", 1},
+		{[]string{"-x", "if $*init; $cond {}", "-x", "$cond"}, "if a := f(); b {}", "b"},
+		{
+			[]string{"-x", "if $x != nil { $*body }", "-s", "if v := $x; v != nil { $body }", "-w"},
+			"if x != nil { f(x) }",
+			"if v := x; v != nil { f(x); }",
+		},
+		{
+			[]string{"-x", "if $*init; $cond { $*body }", "-s", "if $*init; !($cond) { $body }", "-w"},
+			"if x := f(); x { g() }",
+			"if x := f(); !(x) { g(); }",
+		},
+		{
+			[]string{"-x", "if $*init; $cond { $*body }", "-s", "if $*init; !($cond) { $body }", "-w"},
+			"if x { g() }",
+			"if !(x) { g(); }",
+		},
+		{
+			[]string{"-x", "if $*init; $cond { $*body }", "-s", "if y := h(); $cond { $body }", "-w"},
+			"if x := f(); x { g() }",
+			"if y := h(); x { g(); }",
+		},
+		{[]string{"-x", "switch $*init; $tag { $*_ }", "-x", "$tag"}, "switch a := f(); b {}", "b"},
+		{[]string{"-x", "switch $*init; $tag { $*_ }", "-x", "$tag"}, "switch b {}", "b"},
 		// TODO: should these match?
 		//{[]string{"-x", "if a(); $*x { f($*x) }"}, "if a(); b { f(b) }", 1},
 		//{[]string{"-x", "if a(); $*x { f($*x) }"}, "if a(); b { f(b, c) }", 0},
@@ -666,7 +1943,32 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "switch x := y.(z); x {}"}, "switch x := y.(z); x {}", 1},
 		{[]string{"-x", "switch x := y.(z); x {}"}, "switch y := y.(z); x {}", 0},
 		{[]string{"-x", "switch x := y.(z); x {}"}, "switch y := y.(z); x {}", 0},
-		// TODO more switch variations.
+		{[]string{"-x", "switch $x := $y.(type) { $*_ }"}, "switch x := y.(type) {}; switch y.(type) {}", 1},
+		{[]string{"-x", "switch $y.(type) { $*_ }"}, "switch x := y.(type) {}; switch y.(type) {}", 1},
+		{[]string{"-x", "switch $*_; $y.(type) { $*_ }"}, "switch x := y.(type) {}; switch f(); y.(type) {}", 1},
+		{[]string{"-x", "switch $*_ { $*_ }"}, "switch x := y.(type) {}; switch y.(type) {}; switch f(); x {}", 3},
+		{[]string{"-x", "switch $*_ { case int: $*_ }"}, "switch x := y.(type) { case int: }; switch y { case int: }", 2},
+		{
+			[]string{"-x", "switch $x := $y.(type) { case $T: $*_ }", "-x", "$T"},
+			"switch x := y.(type) { case *MyError: f(x) }",
+			"*MyError",
+		},
+		{
+			[]string{"-x", "switch $x := $y.(type) { case $T: $*_; case $U: $*_ }", "-x", "$U"},
+			"switch x := y.(type) { case int: f(x); case string: g(x) }",
+			"string",
+		},
+		{
+			[]string{"-x", "switch $x := $y.(type) { $*_; case $*T: $*_ }", "-x", "$*T"},
+			"switch x := y.(type) { case int: f(x); case error, fmt.Stringer: g(x) }",
+			"error, fmt.Stringer",
+		},
+		// one match per case, within any type switch
+		{
+			[]string{"-x", "switch $*_ { $*_ }", "-x", "case $T: $*_", "-x", "$T"},
+			"switch x := y.(type) { case int: f(x); case *MyError: g(x); case error, fmt.Stringer: }",
+			2, // int and *MyError
+		},
 
 		// TODO select statement
 		// TODO communication clause
@@ -676,6 +1978,21 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "select {$a; $a}"}, "select {case <-x: a; case <-x: b}", 0},
 		{[]string{"-x", "select {case x := <-y: f(x)}"}, "select {case x := <-y: f(x)}", 1},
 
+		// case and default clauses on their own
+		{[]string{"-x", "default: $*_"}, "switch x { case 1: a; default: b }", "default: b"},
+		{[]string{"-x", "default: $*_"}, "switch x { case 1: a }", 0},
+		{[]string{"-x", "default: $*_"}, "select { case <-x: a; default: b }", "default: b"},
+		{[]string{"-x", "default: $*_"}, "select { case <-x: a }", 0},
+		{[]string{"-x", "default:"}, "switch { default: b }; switch { default: }", 1},
+		{[]string{"-x", "case $*_: $*_"}, "switch x { case 1: a; default: b }", 2},
+		{[]string{"-x", "case $_, $*_: $*_"}, "switch x { case 1: a; default: b }", "case 1: a"},
+		{[]string{"-x", "case $_: $*_"}, "select { case <-x: a; default: b }", 0},
+		{[]string{"-x", "case <-$_: $*_"}, "select { case <-x: a; default: b }", "case <-x: a"},
+		{[]string{"-x", "case <-$_: $*_"}, "switch { case <-x: a }", 0},
+		{[]string{"-x", "case $_ := <-$_: $*_"}, "select { case v := <-x: a }", 1},
+		{[]string{"-x", "case 1: $*_; default: $*_"}, "switch x { case 1: a; default: b }", 1},
+		{[]string{"-x", "switch $*_ { $*_ }", "-v", "default: $*_"}, "switch x { case 1: a; default: b }; switch y { case 2: c }", "switch y { case 2: c; }"},
+
 		// aggressive mode
 		{[]string{"-x", "for range $x {}"}, "for _ = range a {}", 0},
 		{[]string{"-x", "~ for range $x {}"}, "for _ = range a {}", 1},
@@ -690,6 +2007,43 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "a := b"}, "a = b; a := b", 1},
 		{[]string{"-x", "~ a = b"}, "a = b; a := b; var a = b", 3},
 		{[]string{"-x", "~ a := b"}, "a = b; a := b; var a = b", 3},
+		{[]string{"-x", "[2]int"}, "var _ [1+1]int", 0},
+		{[]string{"-x", "~ [2]int"}, "var _ [1+1]int", 1},
+		{[]string{"-x", "~ [1+1]int"}, "var _ [2]int", 1},
+		{[]string{"-x", "~ [2]int"}, "const n = 2; var _ [n]int", 1},
+		{[]string{"-x", "~ [3]int"}, "var _ [1+1]int", 0},
+		{[]string{"-x", "~ [2]int"}, "var _ [1+1]byte", 0},
+		{[]string{"-x", "a == b"}, "b == a", 0},
+		{[]string{"-x", "~ a == b"}, "b == a", 1},
+		{[]string{"-x", "~ a == b"}, "a == b", 1},
+		{[]string{"-x", "~ $x == $x"}, "a == b", 0},
+		{[]string{"-x", "~ $x == a && $x != b"}, "a == c && b != c", 1},
+		{[]string{"-x", "~ $x == a && $x != b"}, "a == c && b != d", 0},
+		{[]string{"-x", "~ a - b"}, "b - a", 0},
+		{[]string{"-x", "~ 1 + 2"}, "var _ = 2 + 1", 1},
+		{[]string{"-x", `~ "a" + "b"`}, `var _ = "b" + "a"`, 0},
+
+		// goroutines that can't be cancelled
+		{
+			[]string{"-x", "go func() { $*_ }()", "-v", "$_.Done()"},
+			"go func() { for { work() } }()",
+			1,
+		},
+		{
+			[]string{"-x", "go func() { $*_ }()", "-v", "$_.Done()"},
+			"go func() { for { select { case <-ctx.Done(): return; default: work() } } }()",
+			0,
+		},
+		{
+			[]string{"-x", "go func() { $*_ }()", "-v", "$_.Done()"},
+			"go func() { <-done.Done(); cleanup() }()",
+			0,
+		},
+		{
+			[]string{"-x", "go func() { $*_ }()", "-v", "$_.Done()", "-v", "<-$_"},
+			"go func() { <-quit; cleanup() }(); go func() { work() }()",
+			"go func() { work(); }()",
+		},
 
 		// many cmds
 		{
@@ -712,11 +2066,114 @@ func TestMatch(t *testing.T) {
 			"break; for {}; for { x() }; for { break }",
 			2,
 		},
+		{
+			[]string{"-x", "func $f($*_) { $*_ }", "-bind", "call $f($*_)"},
+			"func a() { a() }; func b() { a() }",
+			"func a() { a(); }",
+		},
+		{
+			[]string{"-x", "func $_() { $*_ }", "-bind", "v var $x int", "-g", "$x++"},
+			"func a() { var i int; i++ }; func b() { var i int; j++ }",
+			"func a() { var i int; i++; }",
+		},
+		{
+			[]string{"-x", "func $_() { $*_ }", "-bind", "$v var $x int", "-x", "$v"},
+			"func a() { var i int; var j int }; func b() {}",
+			"var i int",
+		},
+		{
+			[]string{"-x", "if $_ { $*_ }", "-bind", "r return $_", "-s", "$r", "-w"},
+			"func f() int { if x { a(); return 1 }; if y { b() }; return 2 }",
+			"func f() int { return 1; if y { b(); }; return 2; }",
+		},
+		{
+			[]string{"-x", "$x + $y", "-repeated", "2"},
+			"f(a + 1); f(b + 1); f(a + 1); f(a+1)",
+			3,
+		},
+		{
+			[]string{"-x", "$x + $y", "-repeated", "2"},
+			"f(a + 1); f(b + 1)",
+			0,
+		},
+		{
+			[]string{"-x", "f($x)", "-x", "$x", "-repeated", "3"},
+			`f("foo"); f("bar"); f("foo"); f("foo"); f("bar")`,
+			3,
+		},
+		{
+			[]string{"-x", "$x + $y", "-repeated", "1"},
+			"f(a + 1); f(b + 1)",
+			2,
+		},
+		{
+			[]string{"-f", "testdata/patterns.txt"},
+			"print(a); foo(b); println(c, d)",
+			2,
+		},
+		{
+			[]string{"-x", "if err != nil { return $x }", "-s", "@testdata/subst.txt", "-w"},
+			"func f() error { if err != nil { return g() }; return nil }",
+			"func f() error { if err != nil { log(g(), err); return err; }; return nil; }",
+		},
+		{
+			[]string{"-f", "testdata/patterns.txt", "-x", "$x", "-a", "rx(`d`)"},
+			"println(c, d); print(a)",
+			1,
+		},
+		{
+			[]string{"-x", "f($*_)", "-f", "testdata/patterns.txt"},
+			"f(print(a), println(b)); print(c)",
+			2,
+		},
+		{
+			[]string{"-token-regex", "-x", `b\(\)`},
+			"func f() { a() }; func g() { b() }; func h() { b() }",
+			2,
+		},
+		{
+			[]string{"-token-regex", "-x", `\{ a\(\); b\(\); \}`},
+			"func f() {\n\ta()\n\tb()\n}; func g() { b() }",
+			"func f() { a(); b(); }",
+		},
+		{
+			[]string{"-token-regex", "-x", `^func`, "-g", "b()"},
+			"var _ = b(); func f() { a() }; func g() { b() }",
+			"func g() { b(); }",
+		},
 		{
 			[]string{"-x", "for { $*sts }", "-x", "$*sts"},
 			"for { a(); b() }",
 			"a(); b()",
 		},
+		{
+			[]string{"-x", "func $f($*_) { $*body }", "-x", "$*body"},
+			"func f() { a(); b() }; func g() {}",
+			"a(); b()",
+		},
+		{
+			[]string{"-x", "func $f($*_) { $*body }", "-x", "$*body", "-x", "b()"},
+			"func f() { a(); b() }; func g() { b() }; func h() {}",
+			2,
+		},
+		{
+			// only wildcards in the replacement fill names like these
+			[]string{"-x", "foo", "-s", "bar", "-w"},
+			"func foo() {}; func f(t T) { foo(); t.foo() }",
+			"package p; func foo() { }; func f(t T) { bar(); t.foo(); }",
+		},
+		{
+			[]string{"-x", "func $f() { $*body }", "-s", "func $f() { $body; trace() }", "-w"},
+			"func f() { a(); b() }; func g() {}",
+			"package p; func f() { a(); b(); trace(); }; func g() { trace(); }",
+		},
+		{
+			// f(1) was moved into h as $x, so it is left alone
+			[]string{"-x", "f($x)", "-s", "h($x)", "-w"},
+			"func g() { f(f(1)) }",
+			"func g() { h(f(1)); }",
+		},
+		{[]string{"-x", "f($x)", "-s", "h($x)"}, "f(f(1))", "h(f(1))"},
 		{
 			[]string{"-x", "for { $*sts }", "-x", "$*sts"},
 			"for { if x { a(); b() } }",
@@ -792,6 +2249,216 @@ func TestMatch(t *testing.T) {
 			`if b = a(); b { }`,
 			`if c(); b { }`,
 		},
+		{
+			[]string{"-x", "$x != nil", "-stmt"},
+			`{ if err != nil { bar(); }; etc(); }`,
+			`if err != nil { bar(); }`,
+		},
+		{
+			[]string{"-x", "a", "-stmt"},
+			`{ x := a + a; etc(); }`,
+			`x := a + a`,
+		},
+		{
+			[]string{"-x", "foo()", "-stmt"},
+			`{ foo(); }`,
+			`foo()`,
+		},
+		{
+			[]string{"-x", "a(); b()", "-stmt"},
+			`{ a(); b(); c(); }`,
+			`a(); b()`,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`a`)", "-stmt"},
+			`var v = a`,
+			0,
+		},
+		{
+			[]string{"-x", "a", "-stmt"},
+			`a, b`,
+			0,
+		},
+		{
+			[]string{"-x", "fmt.Errorf($*_)", "-wrapverb", "-w"},
+			`import "fmt"; func f(n int, err error) { _ = fmt.Errorf("%d: %v", n, err); _ = fmt.Errorf(` + "`%s %v`" + `, err, n) }`,
+			`package p; import "fmt"; func f(n int, err error) { _ = fmt.Errorf("%d: %w", n, err); _ = fmt.Errorf(` + "`%w %v`" + `, err, n); }`,
+		},
+		{
+			[]string{"-x", "fmt.Errorf($*_)", "-wrapverb", "-w"},
+			`import "fmt"; const format = "%v"; func f(err error) { _ = fmt.Errorf(format, err) }`,
+			`package p; import "fmt"; const format = "%v"; func f(err error) { _ = fmt.Errorf(format, err); }`,
+		},
+		{
+			[]string{"-x", "$f()", "-wrapverb", "-w"},
+			`func f() { g() }`,
+			`func f() { g(); }`,
+		},
+		{
+			[]string{"-x", "$x + $y", "-outermost"},
+			`{ x := a + b + c; y := f(d + e) + g }`,
+			2, // a + b + c and f(d + e) + g
+		},
+		{
+			[]string{"-x", "$x + $y"},
+			`{ x := a + b + c; y := f(d + e) + g }`,
+			4,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-outermost"},
+			`{ f(g(h())); i() }`,
+			2, // f(g(h())) and i()
+		},
+		{
+			[]string{"-x", "$x + $y", "-innermost"},
+			`{ x := a + b + c; y := f(d + e) + g }`,
+			2, // a + b and d + e
+		},
+		{
+			[]string{"-x", "$f($*_)", "-innermost"},
+			`{ f(g(h())); i() }`,
+			2, // h() and i()
+		},
+		{
+			[]string{"-x", "$f($*_)", "-innermost"},
+			`{ f(g(h())) }`,
+			"h()",
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`a`)", "-outermost"},
+			`{ a; a }`,
+			2, // neither contains the other
+		},
+		{
+			[]string{"-x", "$x + $x", "-stmt", "-s", "return $x", "-w"},
+			`func f() int { x := a + a; return x }`,
+			`func f() int { return a; return x; }`,
+		},
+		{
+			[]string{"-x", "foo($x)", "-suggest", "bar($x)", "-w"},
+			`{ foo(a); foo(b) }`,
+			`{ foo(a); foo(b); }`,
+		},
+		{
+			[]string{"-x", "foo($x)", "-suggest", "bar($x)", "-p", "2"},
+			`{ foo(a) }`,
+			`{ foo(a); }`,
+		},
+		{
+			[]string{"-x", "foo()", "-wrap", "log.Wrap($_)", "-w"},
+			`{ foo(); bar(foo()) }`,
+			`{ log.Wrap(foo()); bar(log.Wrap(foo())); }`,
+		},
+		{
+			[]string{"-x", "a + b", "-wrap", "($_) * 2", "-w"},
+			`{ x := a + b; y := a + b + c }`,
+			`{ x := (a + b) * 2; y := (a+b)*2 + c; }`,
+		},
+		{
+			[]string{"-x", "$x == true", "-s", "$x", "-w"},
+			`{ if a == true {}; if a || b == true {} }`,
+			`{ if a { }; if a || b { }; }`,
+		},
+		{
+			[]string{"-x", "$x != false", "-s", "$x", "-w"},
+			`{ if a != false && b {} }`,
+			`{ if a && b { }; }`,
+		},
+		{
+			[]string{"-x", "$x == false", "-s", "!$x", "-w"},
+			`{ if a == false {}; if (a || b) == false {}; if f(a && b) == false {} }`,
+			`{ if !a { }; if !(a || b) { }; if !f(a && b) { }; }`,
+		},
+		{
+			[]string{"-x", "$x != true", "-s", "!$x", "-w"},
+			`{ if a < b != true {}; if *p != true {} }`,
+			`{ if !(a < b) { }; if !*p { }; }`,
+		},
+		{
+			[]string{"-x", "$x := $y", "-s", "var $x $type($x) = $y", "-w"},
+			`{ x := 3; s := "a" + "b"; var f float64; g := f; p := &f }`,
+			`{ var x int = 3; var s string = "a" + "b"; var f float64; var g float64 = f; var p *float64 = &f; }`,
+		},
+		{
+			[]string{"-x", "$x := $y", "-s", "var $x $type($x) = $y", "-w"},
+			`{ x := 3; y := undefined }`,
+			`{ var x int = 3; y := undefined; }`, // unknown types are skipped
+		},
+		{
+			[]string{"-x", "$x := $y", "-s", "var $x $type($y) = $y", "-w"},
+			`type T struct{}; func f() { t := T{}; m := map[string]T{} }`,
+			`package p; type T struct{}; func f() { var t T = T{}; var m map[string]T = map[string]T{}; }`,
+		},
+		{
+			[]string{"-x", "$x := $y", "-s", "var $x $type($x) = $y", "-w"},
+			`import "bytes"; func f() { b := new(bytes.Buffer) }`,
+			`package p; import "bytes"; func f() { var b *bytes.Buffer = new(bytes.Buffer); }`,
+		},
+		{
+			[]string{"-x", "$x := $y", "-s", "var $x $type($x) = $y", "-w"},
+			`import bs "bytes"; func f() { b := new(bs.Buffer) }`,
+			`package p; import bs "bytes"; func f() { var b *bs.Buffer = new(bs.Buffer); }`,
+		},
+		{
+			[]string{"-x", "$x := $y", "-s", "var $x $type($x) = $y", "-w"},
+			`import "os"; func f() { c, _ := os.Stdin.SyscallConn(); x := c }`,
+			`package p; import "os"; func f() { c, _ := os.Stdin.SyscallConn(); x := c; }`, // syscall isn't imported
+		},
+		{
+			[]string{"-x", "f($x)", "-s", "g($type($x))", "-w"},
+			`{ f(nil); f(1) }`,
+			`{ f(nil); g(int); }`, // untyped nil has no type to write
+		},
+		{
+			[]string{"-x", "f($x)", "-s", "h($type($x))", "-w"},
+			`func g() (int, int) { return 0, 0 }; func f(a, b int) {}; func _() { f(g()) }`,
+			`package p; func g() (int, int) { return 0, 0; }; func f(a, b int) { }; func _() { f(g()); }`,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`a`)", "-s", "b + c", "-w"},
+			`{ x := a * 2; y := 2 - a; z := -a; a.f() }`,
+			`{ x := (b + c) * 2; y := 2 - (b + c); z := -(b + c); (b + c).f(); }`,
+		},
+		{
+			[]string{"-x", "f($x)", "-wrap", "g($_, $x)", "-w"},
+			`{ f(1); f(2) }`,
+			`{ g(f(1), 1); g(f(2), 2); }`,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`a`)", "-s", "wrap($x)", "-w"},
+			`{ f(a, b) }`,
+			`{ f(wrap(a), b); }`,
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-positional"},
+			"type T struct{ A, B int }; var _ = T{B: 2, A: 1}",
+			"T{1, 2}",
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-positional", "-w"},
+			"type T struct{ A, B int }; var _ = []T{T{B: 2, A: 1}, T{A: 3}, T{4, 5}}",
+			"package p; type T struct{ A, B int }; var _ = []T{T{1, 2}, T{A: 3}, T{4, 5}}",
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-keyed"},
+			"type T struct{ A, B int }; var _ = T{1, 2}",
+			"T{A: 1, B: 2}",
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-keyed", "-w"},
+			"type T struct{ A, B int }; var _ = []T{T{1, 2}, T{A: 3}, T{B: 4, A: 5}}",
+			"package p; type T struct{ A, B int }; var _ = []T{T{A: 1, B: 2}, T{A: 3}, T{B: 4, A: 5}}",
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-positional", "-keyed"},
+			"type T struct{ A, B int }; var _ = T{B: 2, A: 1}",
+			"T{A: 1, B: 2}",
+		},
+		{
+			[]string{"-x", "$_{$*_}", "-positional"},
+			"var _ = map[string]int{\"a\": 1}",
+			`map[string]int{"a": 1}`,
+		},
 		{
 			[]string{"-x", "foo()", "-p", "1"},
 			`{ if foo() { bar(); }; etc(); }`,
@@ -841,6 +2508,23 @@ func TestMatchMultiline(t *testing.T) {
 	}
 }
 
+func TestUnvendor(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"golang.org/x/net/http2", "golang.org/x/net/http2"},
+		{"foo.com/bar/vendor/golang.org/x/net/http2", "golang.org/x/net/http2"},
+		{"vendor/golang.org/x/net/http2", "golang.org/x/net/http2"},
+		{"foo.com/vendor/bar/vendor/baz.com/qux", "baz.com/qux"},
+		{"foo.com/vendorbar/qux", "foo.com/vendorbar/qux"},
+	}
+	for _, tc := range tests {
+		if got := unvendor(tc.path); got != tc.want {
+			t.Errorf("unvendor(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
 func grepTest(t *testing.T, args []string, src string, want interface{}) {
 	tfatalf := func(format string, a ...interface{}) {
 		t.Fatalf("%v | %q: %s", args, src, fmt.Sprintf(format, a...))
@@ -857,19 +2541,21 @@ func grepTest(t *testing.T, args []string, src string, want interface{}) {
 
 	// Type-checking is attempted on a best-effort basis.
 	m.Info = &types.Info{
-		Types:  make(map[ast.Expr]types.TypeAndValue),
-		Defs:   make(map[*ast.Ident]types.Object),
-		Uses:   make(map[*ast.Ident]types.Object),
-		Scopes: make(map[ast.Node]*types.Scope),
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Scopes:     make(map[ast.Node]*types.Scope),
 	}
 	pkg := types.NewPackage("", "")
 	config := &types.Config{
-		Importer: importer.Default(),
-		Error:    func(error) {}, // don't stop at the first error
+		GoVersion: m.lang,
+		Importer:  importer.Default(),
+		Error:     func(error) {}, // don't stop at the first error
 	}
 	check := types.NewChecker(config, m.fset, pkg, m.Info)
 	_ = check.Files([]*ast.File{file})
-	m.scope = pkg.Scope()
+	m.scope, m.pkg = pkg.Scope(), pkg
 
 	matches := m.matches(cmds, []ast.Node{srcNode})
 	if want, ok := want.(wantErr); ok {
@@ -909,3 +2595,35 @@ func grepTest(t *testing.T, args []string, src string, want interface{}) {
 		tfatalf("wanted %q, got %q", wantStr, got)
 	}
 }
+
+func BenchmarkMatch(b *testing.B) {
+	// a large generated file, with many small functions
+	var sb strings.Builder
+	sb.WriteString("package p\n")
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&sb, "func f%d(a, b int) int { if a > b { return a - b }; return foo(a, b) }\n", i)
+	}
+	src := sb.String()
+	for _, args := range [][]string{
+		{"-x", "foo($x, $y)"},
+		{"-x", "foo($x, $y)", "-p", "1"},
+	} {
+		b.Run(strings.Join(args, " "), func(b *testing.B) {
+			m := matcher{fset: token.NewFileSet()}
+			cmds, _, err := m.parseCmds(args)
+			if err != nil {
+				b.Fatal(err)
+			}
+			srcNode, _, err := parseDetectingNode(m.fset, src)
+			if err != nil {
+				b.Fatal(err)
+			}
+			m.Info = &types.Info{}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.matches(cmds, []ast.Node{srcNode})
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
@@ -36,7 +37,18 @@ func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
 		m.aggressive = true
 	}
 	lastLit := false
-	for _, t := range toks {
+	inImport, importParen := false, false
+	for i, t := range toks {
+		switch t.tok {
+		case token.IMPORT:
+			inImport = true
+		case token.LPAREN:
+			importParen = inImport
+		case token.RPAREN:
+			inImport, importParen = false, false
+		case token.SEMICOLON:
+			inImport = importParen
+		}
 		if lbuf.offs >= t.pos.Offset && lastLit && t.lit != "" {
 			lbuf.WriteString(" ")
 		}
@@ -53,6 +65,13 @@ func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
 			// info attached to ident name strings
 			addOffset(len(wildPrefix) - 1)
 		}
+		if inImport && isWildName(t.lit) && isImportPath(toks[i+1:]) {
+			// import paths must be string literals
+			addOffset(2)
+			lbuf.WriteString(strconv.Quote(t.lit))
+			lastLit = true
+			continue
+		}
 		lbuf.WriteString(t.lit)
 		lastLit = strings.TrimSpace(t.lit) != ""
 	}
@@ -60,27 +79,64 @@ func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
 	return strings.TrimSpace(lbuf.String()), offs, nil
 }
 
+// isImportPath reports whether a token within an import declaration is its
+// path, given the tokens that follow it.
+func isImportPath(rest []fullToken) bool {
+	if len(rest) == 0 {
+		return true
+	}
+	switch rest[0].tok {
+	case token.IDENT, token.STRING:
+		return false
+	}
+	return true
+}
+
 func (m *matcher) parseExpr(expr string) (ast.Node, error) {
+	kind, expr, kindLen := splitNodeKind(expr)
 	exprStr, offs, err := m.transformSource(expr)
 	if err != nil {
 		return nil, err
 	}
-	node, _, err := parseDetectingNode(m.fset, exprStr)
+	node, _, err := parseNodeKind(m.fset, exprStr, kind)
 	if err != nil {
 		err = subPosOffsets(err, offs...)
+		// point at the pattern as written, including its hint
+		err = subPosOffsets(err, posOffset{1, 1, -kindLen})
 		return nil, fmt.Errorf("cannot parse expr: %v", err)
 	}
 	return node, nil
 }
 
+// nodeKinds are the hints which may prefix a pattern, like "stmt: x := y", to
+// force it to be parsed as a particular kind of node.
+var nodeKinds = [...]string{"file", "decl", "stmt", "expr", "type"}
+
+// splitNodeKind splits a node kind hint from the start of a pattern, if there
+// is one. The hint must be on the first line, and prefixLen is the number of
+// bytes before the first token of the rest of the pattern.
+func splitNodeKind(src string) (kind, rest string, prefixLen int) {
+	trimmed := strings.TrimLeft(src, " \t")
+	for _, kind := range nodeKinds {
+		after := strings.TrimPrefix(trimmed, kind+":")
+		if after == trimmed || strings.HasPrefix(after, "=") {
+			// not a hint, or an assignment like "expr:= x"
+			continue
+		}
+		return kind, after, len(src) - len(strings.TrimLeft(after, " \t"))
+	}
+	return "", src, 0
+}
+
 type lineColBuffer struct {
 	bytes.Buffer
 	line, col, offs int
 }
 
 func (l *lineColBuffer) WriteString(s string) (n int, err error) {
-	for _, r := range s {
-		if r == '\n' {
+	// go/scanner positions count bytes, not runes
+	for _, b := range []byte(s) {
+		if b == '\n' {
 			l.line++
 			l.col = 1
 		} else {
@@ -109,6 +165,12 @@ var tmplType = template.Must(template.New("").Parse(`` +
 var tmplValSpec = template.Must(template.New("").Parse(`` +
 	`package p; var {{ . }}`))
 
+var tmplSwitchCases = template.Must(template.New("").Parse(`` +
+	`package p; func _() { switch { {{ . }} } }`))
+
+var tmplSelectCases = template.Must(template.New("").Parse(`` +
+	`package p; func _() { select { {{ . }} } }`))
+
 func execTmpl(tmpl *template.Template, src string) string {
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, src); err != nil {
@@ -117,6 +179,13 @@ func execTmpl(tmpl *template.Template, src string) string {
 	return buf.String()
 }
 
+// tmplOffset returns the number of bytes a template puts before its source,
+// which is all on the first line.
+func tmplOffset(tmpl *template.Template) int {
+	const marker = "\x00"
+	return strings.Index(execTmpl(tmpl, marker), marker)
+}
+
 func noBadNodes(node ast.Node) bool {
 	any := false
 	ast.Inspect(node, func(n ast.Node) bool {
@@ -148,80 +217,177 @@ func parseType(fset *token.FileSet, src string) (ast.Expr, *ast.File, error) {
 // It also returns the *ast.File used for the parsing, so that the returned node
 // can be easily type-checked.
 func parseDetectingNode(fset *token.FileSet, src string) (ast.Node, *ast.File, error) {
+	return parseNodeKind(fset, src, "")
+}
+
+// parseNodeKind is like parseDetectingNode, but only tries to parse src as the
+// given kind of node, one of nodeKinds. An empty kind tries all of them.
+func parseNodeKind(fset *token.FileSet, src, kind string) (ast.Node, *ast.File, error) {
 	file := fset.AddFile("", fset.Base(), len(src))
 	scan := scanner.Scanner{}
 	scan.Init(file, []byte(src), nil, 0)
 	if _, tok, _ := scan.Scan(); tok == token.EOF {
 		return nil, nil, fmt.Errorf("empty source code")
 	}
+	is := func(k string) bool { return kind == "" || kind == k }
 	var mainErr error
+	// with a hint, the error for that kind of node is the only one that
+	// makes sense
+	hintErr := func(err error, prefix int) {
+		if kind != "" && mainErr == nil && err != nil {
+			mainErr = subPosOffsets(err, posOffset{1, 1, prefix})
+		}
+	}
 
 	// first try as a whole file
-	if f, err := parser.ParseFile(fset, "", src, 0); err == nil && noBadNodes(f) {
-		return f, f, nil
+	if is("file") {
+		f, err := parser.ParseFile(fset, "", src, 0)
+		if err == nil && noBadNodes(f) {
+			return f, f, nil
+		}
+		hintErr(err, 0)
 	}
 
 	// then as a single declaration, or many
-	asDecl := execTmpl(tmplDecl, src)
-	if f, err := parser.ParseFile(fset, "", asDecl, 0); err == nil && noBadNodes(f) {
-		if len(f.Decls) == 1 {
-			return f.Decls[0], f, nil
+	if is("decl") {
+		asDecl := execTmpl(tmplDecl, src)
+		f, err := parser.ParseFile(fset, "", asDecl, 0)
+		if err == nil && noBadNodes(f) {
+			if len(f.Decls) == 1 {
+				if gd, ok := f.Decls[0].(*ast.GenDecl); ok && gd.Tok == token.IMPORT &&
+					!gd.Lparen.IsValid() {
+					// match single imports within groups too
+					return gd.Specs[0], f, nil
+				}
+				return f.Decls[0], f, nil
+			}
+			return f, f, nil
 		}
-		return f, f, nil
+		hintErr(err, tmplOffset(tmplDecl))
 	}
 
 	// then as a block; otherwise blocks might be mistaken for composite
 	// literals further below
-	asBlock := execTmpl(tmplBlock, src)
-	if f, err := parser.ParseFile(fset, "", asBlock, 0); err == nil && noBadNodes(f) {
-		bl := f.Decls[0].(*ast.FuncDecl).Body
-		if len(bl.List) == 1 {
-			ifs := bl.List[0].(*ast.IfStmt)
-			return ifs.Body, f, nil
+	if is("stmt") {
+		asBlock := execTmpl(tmplBlock, src)
+		if f, err := parser.ParseFile(fset, "", asBlock, 0); err == nil && noBadNodes(f) {
+			bl := f.Decls[0].(*ast.FuncDecl).Body
+			if len(bl.List) == 1 {
+				ifs := bl.List[0].(*ast.IfStmt)
+				return ifs.Body, f, nil
+			}
 		}
 	}
 
 	// then as value expressions
-	asExprs := execTmpl(tmplExprs, src)
-	if f, err := parser.ParseFile(fset, "", asExprs, 0); err == nil && noBadNodes(f) {
-		vs := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
-		cl := vs.Values[0].(*ast.CompositeLit)
-		if len(cl.Elts) == 1 {
-			return cl.Elts[0], f, nil
+	if is("expr") {
+		asExprs := execTmpl(tmplExprs, src)
+		f, err := parser.ParseFile(fset, "", asExprs, 0)
+		if err == nil && noBadNodes(f) {
+			vs := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
+			cl := vs.Values[0].(*ast.CompositeLit)
+			if len(cl.Elts) == 1 {
+				return cl.Elts[0], f, nil
+			}
+			return exprList(cl.Elts), f, nil
 		}
-		return exprList(cl.Elts), f, nil
+		hintErr(err, tmplOffset(tmplExprs))
 	}
 
 	// then try as statements
-	asStmts := execTmpl(tmplStmts, src)
-	if f, err := parser.ParseFile(fset, "", asStmts, 0); err == nil && noBadNodes(f) {
-		bl := f.Decls[0].(*ast.FuncDecl).Body
-		if len(bl.List) == 1 {
-			return bl.List[0], f, nil
+	if is("stmt") {
+		asStmts := execTmpl(tmplStmts, src)
+		if f, err := parser.ParseFile(fset, "", asStmts, 0); err == nil && noBadNodes(f) {
+			bl := f.Decls[0].(*ast.FuncDecl).Body
+			if len(bl.List) == 1 {
+				return bl.List[0], f, nil
+			}
+			return stmtList(bl.List), f, nil
+		} else {
+			// Statements is what covers most cases, so it will give
+			// the best overall error message. Show positions
+			// relative to where the user's code is put in the
+			// template.
+			mainErr = subPosOffsets(err, posOffset{1, 1, tmplOffset(tmplStmts)})
 		}
-		return stmtList(bl.List), f, nil
-	} else {
-		// Statements is what covers most cases, so it will give
-		// the best overall error message. Show positions
-		// relative to where the user's code is put in the
-		// template.
-		mainErr = subPosOffsets(err, posOffset{1, 1, 22})
 	}
 
 	// type expressions not yet picked up, for e.g. chans and interfaces
-	if typ, f, err := parseType(fset, src); err == nil && noBadNodes(f) {
-		return typ, f, nil
+	if is("type") {
+		typ, f, err := parseType(fset, src)
+		if err == nil && noBadNodes(f) {
+			return typ, f, nil
+		}
+		hintErr(err, 0)
 	}
 
 	// value specs
-	asValSpec := execTmpl(tmplValSpec, src)
-	if f, err := parser.ParseFile(fset, "", asValSpec, 0); err == nil && noBadNodes(f) {
-		vs := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
-		return vs, f, nil
+	if kind == "" {
+		asValSpec := execTmpl(tmplValSpec, src)
+		if f, err := parser.ParseFile(fset, "", asValSpec, 0); err == nil && noBadNodes(f) {
+			vs := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.ValueSpec)
+			return vs, f, nil
+		}
+	}
+
+	if is("stmt") {
+		// select clauses, if they send or receive
+		asSelect := execTmpl(tmplSelectCases, src)
+		if f, err := parser.ParseFile(fset, "", asSelect, 0); err == nil && noBadNodes(f) {
+			bl := f.Decls[0].(*ast.FuncDecl).Body
+			sel := bl.List[0].(*ast.SelectStmt)
+			if commClauses(sel.Body.List) {
+				return clauses(sel.Body.List), f, nil
+			}
+		}
+
+		// switch clauses, including a lone default clause
+		asSwitch := execTmpl(tmplSwitchCases, src)
+		if f, err := parser.ParseFile(fset, "", asSwitch, 0); err == nil && noBadNodes(f) {
+			bl := f.Decls[0].(*ast.FuncDecl).Body
+			sw := bl.List[0].(*ast.SwitchStmt)
+			return clauses(sw.Body.List), f, nil
+		}
+	}
+	if kind != "" && mainErr == nil {
+		mainErr = fmt.Errorf("cannot parse as %s", kind)
 	}
 	return nil, nil, mainErr
 }
 
+func clauses(list []ast.Stmt) ast.Node {
+	if len(list) == 1 {
+		return list[0]
+	}
+	return stmtList(list)
+}
+
+// commClauses reports whether a list of select clauses has at least one
+// communication, and whether all of them are sends or receives. Otherwise,
+// the clauses are better parsed as switch clauses.
+func commClauses(list []ast.Stmt) bool {
+	any := false
+	for _, stmt := range list {
+		var x ast.Expr
+		switch comm := stmt.(*ast.CommClause).Comm.(type) {
+		case nil:
+			continue
+		case *ast.SendStmt:
+			any = true
+			continue
+		case *ast.ExprStmt:
+			x = comm.X
+		case *ast.AssignStmt:
+			x = comm.Rhs[0]
+		}
+		if ue, ok := x.(*ast.UnaryExpr); !ok || ue.Op != token.ARROW {
+			return false
+		}
+		any = true
+	}
+	return any
+}
+
 type posOffset struct {
 	atLine, atCol int
 	offset        int
@@ -276,6 +442,7 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 		switch msg { // allow certain extra chars
 		case `illegal character U+0024 '$'`:
 		case `illegal character U+007E '~'`:
+		case `illegal character U+003F '?'`:
 		default:
 			err = fmt.Errorf("%v: %s", pos, msg)
 		}
@@ -285,7 +452,13 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 	// enable some features such as regexes.
 	s.Init(file, src, onError, scanner.ScanComments)
 
+	var peeked []fullToken
 	next := func() fullToken {
+		if len(peeked) > 0 {
+			t := peeked[0]
+			peeked = peeked[1:]
+			return t
+		}
 		pos, tok, lit := s.Scan()
 		return fullToken{fset.Position(pos), tok, lit}
 	}
@@ -294,6 +467,11 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 
 	var toks []fullToken
 	for t := next(); t.tok != token.EOF; t = next() {
+		if t.tok.String() == "~" {
+			// newer Go versions scan ~ as a token for type
+			// constraints, instead of an illegal character
+			t.lit = "~"
+		}
 		switch t.lit {
 		case "$": // continues below
 		case "~":
@@ -317,6 +495,20 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 		if err != nil {
 			return nil, err
 		}
+		if caseStat != caseHere && len(toks) > 0 && endsOperand(toks[len(toks)-1].tok) {
+			id := len(m.vars) - 1
+			info := m.vars[id]
+			t := next()
+			if t.tok == token.ASSIGN && !info.any && !info.optional &&
+				t.pos.Offset == wt.pos.Offset+1+len(info.name) {
+				// "$x $op= $y", with $op being a compound assignment
+				// operator like +=. Turn it into "$x, $op = $y".
+				m.vars[id].assignOp = true
+				toks = append(toks, fullToken{wt.pos, token.COMMA, ""}, wt, t)
+				continue
+			}
+			peeked = append(peeked, t)
+		}
 		if caseStat == caseHere {
 			toks = append(toks, fullToken{wt.pos, token.IDENT, "case"})
 		}
@@ -329,13 +521,28 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 	return toks, err
 }
 
+// endsOperand reports whether a token can be the last one in an operand, such
+// as the left side of an assignment.
+func endsOperand(tok token.Token) bool {
+	switch tok {
+	case token.IDENT, token.INT, token.FLOAT, token.IMAG, token.CHAR,
+		token.STRING, token.RPAREN, token.RBRACK:
+		return true
+	}
+	return false
+}
+
 func (m *matcher) wildcard(pos token.Position, next func() fullToken) (fullToken, error) {
 	wt := fullToken{pos, token.IDENT, wildPrefix}
 	t := next()
 	var info varInfo
-	if t.tok == token.MUL {
+	switch {
+	case t.tok == token.MUL:
 		t = next()
 		info.any = true
+	case t.lit == "?":
+		t = next()
+		info.optional = true
 	}
 	if t.tok != token.IDENT {
 		return wt, fmt.Errorf("%v: $ must be followed by ident, got %v",
@@ -349,7 +556,7 @@ func (m *matcher) wildcard(pos token.Position, next func() fullToken) (fullToken
 }
 
 type typeCheck struct {
-	op   string // "type", "asgn", "conv"
+	op   string // "type", "asgn", "conv", "embeds"
 	expr ast.Expr
 }
 
@@ -363,6 +570,131 @@ type typProperty string
 
 type typUnderlying string
 
+// rangeOver is the kind of underlying type that a range statement iterates
+// over, as used by typUnderlying.
+type rangeOver string
+
+// srcRegexp matches the source of any node, printed in a single line. Unlike
+// the regexp used for identifiers, it is not anchored.
+type srcRegexp struct {
+	rx *regexp.Regexp
+}
+
+// typMethod is the name of a method that a type must have.
+type typMethod string
+
+// pkgPath is the import path of the package that a qualifier refers to.
+type pkgPath string
+
+// dotPkgPath is the import path of a dot-imported package that an
+// unqualified identifier refers to.
+type dotPkgPath string
+
+// typPkgPath is the import path of the package declaring a named type.
+type typPkgPath string
+
+// fileImport is an import path which the file containing a node must import.
+type fileImport string
+
+// argCount bounds the number of arguments in a call. A trailing "..." argument
+// counts as a single one.
+type argCount struct {
+	op string // "args", "minargs", "maxargs"
+	n  int
+}
+
+// assignArity is the number of operands on each side of an assignment.
+type assignArity struct {
+	lhs, rhs int
+}
+
+// diffTypes is a pair of wildcards whose bound values have different types.
+type diffTypes struct {
+	name1, name2 string
+}
+
+// todoCount is the minimum number of TODO or FIXME comments within a func.
+type todoCount int
+
+// loopKind is the form of a for loop: "infinite" like "for {}", "cond" like
+// "for x {}", "clause" like "for i := 0; i < n; i++ {}", or "range".
+type loopKind string
+
+// durBound bounds the constant value of a time.Duration expression.
+type durBound struct {
+	op string // "mindur", "maxdur"
+	d  time.Duration
+}
+
+// pkgName matches the package name declared by the file containing a node.
+// Like the regexp for identifiers, it is anchored.
+type pkgName struct {
+	rx *regexp.Regexp
+}
+
+// callName matches the name of the func or method being called, without
+// any qualifier or receiver. Like the regexp for identifiers, it is anchored.
+type callName struct {
+	rx *regexp.Regexp
+}
+
+// buildExpr matches the "//go:build" constraint of the file containing a node,
+// or the empty string if it has none. Like srcRegexp, it is not anchored.
+type buildExpr struct {
+	rx *regexp.Regexp
+}
+
+// directive is a comment directive such as "//go:generate", whose arguments
+// may be required to match a regular expression.
+type directive struct {
+	name string
+	rx   *regexp.Regexp
+}
+
+func parseDirective(src string) (directive, error) {
+	src = strings.TrimPrefix(strings.TrimSpace(src), "//")
+	name, rxStr := src, ""
+	if i := strings.IndexAny(src, " \t"); i >= 0 {
+		name, rxStr = src[:i], strings.TrimSpace(src[i+1:])
+	}
+	if name == "" {
+		return directive{}, fmt.Errorf("empty directive name")
+	}
+	dir := directive{name: name}
+	if rxStr != "" {
+		rx, err := regexp.Compile(rxStr)
+		if err != nil {
+			return directive{}, err
+		}
+		dir.rx = rx
+	}
+	return dir, nil
+}
+
+// binding is a name given to the first node matching a pattern within each
+// match, via -bind.
+type binding struct {
+	name string
+	node ast.Node
+}
+
+func (m *matcher) parseBinding(src string) (binding, error) {
+	src = strings.TrimSpace(src)
+	i := strings.IndexAny(src, " \t")
+	if i < 0 {
+		return binding{}, fmt.Errorf("wanted a name and a pattern: %q", src)
+	}
+	name := strings.TrimPrefix(src[:i], "$")
+	if !token.IsIdentifier(name) || name == "_" {
+		return binding{}, fmt.Errorf("invalid binding name: %q", src[:i])
+	}
+	node, err := m.parseExpr(src[i+1:])
+	if err != nil {
+		return binding{}, err
+	}
+	return binding{name: name, node: node}, nil
+}
+
 func (m *matcher) parseAttrs(src string) (attribute, error) {
 	var attr attribute
 	toks, err := m.tokenize([]byte(src))
@@ -384,7 +716,13 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 	}
 	op := t.lit
 	switch op { // the ones that don't take args
-	case "comp", "addr":
+	case "comp", "addr", "naked", "reach", "unused", "mapkey", "index",
+		"funclit", "methodvalue", "methodexpr", "ptrrecv", "valrecv",
+		"indefer", "nil", "keyed", "positional", "badprintf",
+		"unkeyed", "ptrbase", "toplevel", "neg", "written", "readonly",
+		"spawn", "inloop", "badpanic", "alwaystrue", "alwaysfalse",
+		"exportedfield", "nowrap", "badctx", "padded", "escapes",
+		"dupcase", "emptybranch":
 		if t = next(); t.tok != token.SEMICOLON {
 			return attr, fmt.Errorf("%v: wanted EOF, got %v", t.pos, t.tok)
 		}
@@ -396,24 +734,53 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 		return attr, fmt.Errorf("%v: wanted (", t.pos)
 	}
 	switch op {
-	case "rx":
+	case "rx", "srcrx", "pkgname", "callname", "build":
 		t = next()
 		rxStr, err := strconv.Unquote(t.lit)
 		if err != nil {
 			return attr, fmt.Errorf("%v: %v", t.pos, err)
 		}
-		if !strings.HasPrefix(rxStr, "^") {
-			rxStr = "^" + rxStr
-		}
-		if !strings.HasSuffix(rxStr, "$") {
-			rxStr = rxStr + "$"
+		if op != "srcrx" && op != "build" {
+			if !strings.HasPrefix(rxStr, "^") {
+				rxStr = "^" + rxStr
+			}
+			if !strings.HasSuffix(rxStr, "$") {
+				rxStr = rxStr + "$"
+			}
 		}
 		rx, err := regexp.Compile(rxStr)
 		if err != nil {
 			return attr, fmt.Errorf("%v: %v", t.pos, err)
 		}
-		attr.under = rx
-	case "type", "asgn", "conv":
+		switch op {
+		case "rx":
+			attr.under = rx
+		case "srcrx":
+			attr.under = srcRegexp{rx}
+		case "pkgname":
+			attr.under = pkgName{rx}
+		case "callname":
+			attr.under = callName{rx}
+		case "build":
+			attr.under = buildExpr{rx}
+		}
+	case "pkg", "typepkg", "dot", "imports":
+		t = next()
+		path, err := strconv.Unquote(t.lit)
+		if err != nil {
+			return attr, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		switch op {
+		case "pkg":
+			attr.under = pkgPath(path)
+		case "typepkg":
+			attr.under = typPkgPath(path)
+		case "dot":
+			attr.under = dotPkgPath(path)
+		case "imports":
+			attr.under = fileImport(path)
+		}
+	case "type", "asgn", "conv", "embeds":
 		t = next()
 		start := t.pos.Offset
 		for open := 1; open > 0; t = next() {
@@ -435,7 +802,76 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 		}
 		attr.under = typeCheck{op, typeExpr}
 		i -= 2 // since we went past RPAREN above
-	case "is":
+	case "args", "minargs", "maxargs", "mintodos":
+		if t = next(); t.tok != token.INT {
+			return attr, fmt.Errorf("%v: wanted number, got %v", t.pos, t.tok)
+		}
+		n, err := strconv.Atoi(t.lit)
+		if err != nil {
+			return attr, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		if op == "mintodos" {
+			attr.under = todoCount(n)
+		} else {
+			attr.under = argCount{op, n}
+		}
+	case "arity":
+		var ns [2]int
+		for i := range ns {
+			if i > 0 {
+				if t = next(); t.tok != token.COMMA {
+					return attr, fmt.Errorf("%v: wanted comma, got %v", t.pos, t.tok)
+				}
+			}
+			if t = next(); t.tok != token.INT {
+				return attr, fmt.Errorf("%v: wanted number, got %v", t.pos, t.tok)
+			}
+			n, err := strconv.Atoi(t.lit)
+			if err != nil {
+				return attr, fmt.Errorf("%v: %v", t.pos, err)
+			}
+			ns[i] = n
+		}
+		attr.under = assignArity{ns[0], ns[1]}
+	case "difftype":
+		var names [2]string
+		for i := range names {
+			if i > 0 {
+				if t = next(); t.tok != token.COMMA {
+					return attr, fmt.Errorf("%v: wanted comma, got %v", t.pos, t.tok)
+				}
+			}
+			if t = next(); !isWildName(t.lit) {
+				return attr, fmt.Errorf("%v: wanted wildcard, got %v", t.pos, t.tok)
+			}
+			names[i] = m.vars[fromWildName(t.lit)].name
+		}
+		attr.under = diffTypes{names[0], names[1]}
+	case "mindur", "maxdur":
+		t = next()
+		durStr, err := strconv.Unquote(t.lit)
+		if err != nil {
+			return attr, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		d, err := time.ParseDuration(durStr)
+		if err != nil {
+			return attr, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		attr.under = durBound{op, d}
+	case "loop":
+		switch t = next(); t.lit {
+		case "infinite", "cond", "clause", "range":
+		default:
+			return attr, fmt.Errorf("%v: unknown loop kind: %q", t.pos,
+				t.lit)
+		}
+		attr.under = loopKind(t.lit)
+	case "method":
+		if t = next(); t.tok != token.IDENT {
+			return attr, fmt.Errorf("%v: wanted method name, got %v", t.pos, t.tok)
+		}
+		attr.under = typMethod(t.lit)
+	case "is", "range":
 		switch t = next(); t.lit {
 		case "basic", "array", "slice", "struct", "interface",
 			"pointer", "func", "map", "chan":
@@ -443,7 +879,11 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 			return attr, fmt.Errorf("%v: unknown type: %q", t.pos,
 				t.lit)
 		}
-		attr.under = typUnderlying(t.lit)
+		if op == "is" {
+			attr.under = typUnderlying(t.lit)
+		} else {
+			attr.under = rangeOver(t.lit)
+		}
 	default:
 		return attr, fmt.Errorf("%v: unknown op %q", opPos, op)
 	}
@@ -6,26 +6,293 @@ package main
 import (
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/token"
+	"go/types"
 	"reflect"
+	"regexp"
+	"strconv"
 )
 
 func (m *matcher) cmdSubst(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	// a match within another one was already copied into the new node
+	// of the outer one, so substituting it too would be lost or mangled
+	contained := containedSubs(subs)
 	for i := range subs {
 		sub := &subs[i]
-		nodeCopy, _ := m.parseExpr(cmd.src)
+		if contained[i] {
+			m.warnNested("substitution", sub.node)
+			continue
+		}
+		values := sub.values
+		if cmd.name == "wrap" {
+			// $_ stands for the match itself
+			node := sub.node
+			if exprStmt, ok := node.(*ast.ExprStmt); ok {
+				node = exprStmt.X
+			}
+			values = valsCopy(values)
+			values["_"] = node
+		}
+		src, ok := m.expandTypes(cmd.src, values, m.enclosingFile(sub.node))
+		if !ok {
+			matches = append(matches, *sub) // left as is
+			continue
+		}
+		nodeCopy, err := m.parseExpr(src)
+		if err != nil {
+			matches = append(matches, *sub)
+			continue
+		}
 		// since we'll want to set positions within the file's
 		// FileSet
 		scrubPositions(nodeCopy)
 
 		m.fillParents(nodeCopy)
-		nodeCopy = m.fillValues(nodeCopy, sub.values)
+		// the matched node itself may be moved into the new one, so
+		// replace it via its original parent
+		parent := m.parentOf(sub.node)
+		nodeCopy = m.fillValues(nodeCopy, values)
+		newParent := m.parentOf(sub.node)
+		m.setParentOf(sub.node, parent)
 		m.substNode(sub.node, nodeCopy)
+		m.setParentOf(sub.node, newParent)
 		sub.node = nodeCopy
+		matches = append(matches, *sub)
+	}
+	return matches
+}
+
+// warnNested warns that a command skipped a node within another node it
+// already rewrote or suggested a rewrite for.
+func (m *matcher) warnNested(what string, node ast.Node) {
+	if m.stderr != nil {
+		fmt.Fprintf(m.stderr, "%v: skipping %s within another one\n",
+			m.fset.Position(node.Pos()), what)
+	}
+}
+
+// cmdSuggest records the substitution for each match, like -s would do, but
+// without modifying the syntax tree.
+func (m *matcher) cmdSuggest(cmd exprCmd, subs []submatch) []submatch {
+	for i := range subs {
+		sub := &subs[i]
+		src, ok := m.expandTypes(cmd.src, sub.values, m.enclosingFile(sub.node))
+		if !ok {
+			continue
+		}
+		nodeCopy, err := m.parseExpr(src)
+		if err != nil {
+			continue
+		}
+		scrubPositions(nodeCopy)
+
+		m.fillParents(nodeCopy)
+		nodeCopy = m.fillValues(nodeCopy, sub.values)
+		sub.fix = nodeCopy
+
+		// the values now belong to the copy too, so point their
+		// parents back to the original tree
+		parent := m.parentOf(sub.node)
+		m.fillParents(sub.node)
+		m.setParentOf(sub.node, parent)
+	}
+	// a suggestion within another can't be applied along with it, as
+	// the outer one already includes the original inner node
+	for i, contained := range containedSubs(subs) {
+		if !contained {
+			continue
+		}
+		subs[i].fix = nil
+		m.warnNested("suggestion", subs[i].node)
+	}
+	return subs
+}
+
+// typeMacro matches the "$type($x)" references in a replacement, which stand
+// for the type of the value bound to $x.
+var typeMacro = regexp.MustCompile(`\$type\(\$(\w+)\)`)
+
+// expandTypes replaces the "$type($x)" references in a replacement with the
+// types of the bound values, as they would be written in file. It reports false
+// if any of the types is unknown, can't be written, or refers to a package that
+// file doesn't import.
+func (m *matcher) expandTypes(src string, values map[string]ast.Node, file *ast.File) (string, bool) {
+	ok := true
+	src = typeMacro.ReplaceAllStringFunc(src, func(ref string) string {
+		name := typeMacro.FindStringSubmatch(ref)[1]
+		expr, _ := values[name].(ast.Expr)
+		if expr == nil {
+			ok = false
+			return ref
+		}
+		typ := m.Info.TypeOf(expr)
+		if typ == nil || typ == types.Typ[types.Invalid] {
+			ok = false
+			return ref
+		}
+		if basic, isBasic := typ.(*types.Basic); isBasic &&
+			basic.Info()&types.IsUntyped != 0 {
+			typ = types.Default(typ)
+		}
+		if typ == types.Typ[types.UntypedNil] {
+			ok = false
+			return ref
+		}
+		if _, isTuple := typ.(*types.Tuple); isTuple {
+			ok = false
+			return ref
+		}
+		return types.TypeString(typ, func(pkg *types.Package) string {
+			if pkg == m.pkg {
+				return ""
+			}
+			name, imported := importName(file, pkg)
+			if !imported {
+				ok = false
+			}
+			return name
+		})
+	})
+	return src, ok
+}
+
+// importName returns the name that file uses to refer to an imported package,
+// which is empty for dot imports. It reports false if file doesn't import the
+// package, or only imports it for its side effects.
+func importName(file *ast.File, pkg *types.Package) (string, bool) {
+	if file == nil {
+		return "", false
+	}
+	for _, imp := range file.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || unvendor(path) != unvendor(pkg.Path()) {
+			continue
+		}
+		switch {
+		case imp.Name == nil:
+			return pkg.Name(), true
+		case imp.Name.Name == "_":
+			continue
+		case imp.Name.Name == ".":
+			return "", true
+		default:
+			return imp.Name.Name, true
+		}
+	}
+	return "", false
+}
+
+// cmdLitForm rewrites struct literals between their keyed and positional
+// forms, following the order of the fields in the struct type. Literals which
+// don't list every field, or which mix both forms, are left untouched.
+func (m *matcher) cmdLitForm(cmd exprCmd, subs []submatch) []submatch {
+	for _, sub := range subs {
+		node := sub.node
+		if exprStmt, ok := node.(*ast.ExprStmt); ok {
+			node = exprStmt.X
+		}
+		lit, ok := node.(*ast.CompositeLit)
+		if !ok {
+			continue
+		}
+		typ := m.Info.TypeOf(lit)
+		if typ == nil {
+			continue
+		}
+		st, ok := typ.Underlying().(*types.Struct)
+		if !ok || st.NumFields() != len(lit.Elts) {
+			continue
+		}
+		if cmd.name == "positional" {
+			if elts := positionalElts(st, lit.Elts); elts != nil {
+				lit.Elts = elts
+			}
+		} else if elts := keyedElts(st, lit.Elts); elts != nil {
+			lit.Elts = elts
+		}
+	}
+	return subs
+}
+
+// cmdWrapVerb rewrites the fmt.Errorf calls which format errors with %v or %s
+// to use %w instead, so that the errors are wrapped. Only format strings which
+// are literals can be rewritten.
+func (m *matcher) cmdWrapVerb(cmd exprCmd, subs []submatch) []submatch {
+	for _, sub := range subs {
+		node := sub.node
+		if exprStmt, ok := node.(*ast.ExprStmt); ok {
+			node = exprStmt.X
+		}
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		offsets := m.unwrappedErrors(call)
+		if len(offsets) == 0 {
+			continue
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		if !ok || lit.Kind != token.STRING {
+			continue
+		}
+		format := []byte(constant.StringVal(m.Info.Types[lit].Value))
+		for _, off := range offsets {
+			format[off] = 'w'
+		}
+		if lit.Value[0] == '`' {
+			lit.Value = "`" + string(format) + "`"
+		} else {
+			lit.Value = strconv.Quote(string(format))
+		}
 	}
 	return subs
 }
 
+// positionalElts returns the values of keyed struct literal elements, in the
+// order of the struct fields. It returns nil if any element isn't keyed.
+func positionalElts(st *types.Struct, elts []ast.Expr) []ast.Expr {
+	index := make(map[string]int, st.NumFields())
+	for i := 0; i < st.NumFields(); i++ {
+		index[st.Field(i).Name()] = i
+	}
+	res := make([]ast.Expr, len(elts))
+	for _, elt := range elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			return nil
+		}
+		i, ok := index[key.Name]
+		if !ok || res[i] != nil {
+			return nil
+		}
+		res[i] = kv.Value
+	}
+	return res
+}
+
+// keyedElts returns positional struct literal elements with their field
+// names as keys. It returns nil if any element is already keyed.
+func keyedElts(st *types.Struct, elts []ast.Expr) []ast.Expr {
+	res := make([]ast.Expr, len(elts))
+	for i, elt := range elts {
+		if _, ok := elt.(*ast.KeyValueExpr); ok {
+			return nil
+		}
+		res[i] = &ast.KeyValueExpr{
+			Key:   &ast.Ident{NamePos: elt.Pos(), Name: st.Field(i).Name()},
+			Colon: elt.Pos(),
+			Value: elt,
+		}
+	}
+	return res
+}
+
 type topNode struct {
 	Node ast.Node
 }
@@ -41,6 +308,18 @@ func (m *matcher) fillValues(node ast.Node, values map[string]ast.Node) ast.Node
 	m.setParentOf(node, top)
 
 	inspect(node, func(node ast.Node) bool {
+		if as, ok := node.(*ast.AssignStmt); ok {
+			// "$x $op= $y" was parsed as "$x, $op = $y"
+			op := m.wildAssignOp(as)
+			if op == nil {
+				return true
+			}
+			if prev, ok := values[m.info(fromWildName(op.Name)).name].(*ast.Ident); ok {
+				as.Lhs = as.Lhs[:len(as.Lhs)-1]
+				as.Tok = assignOpToken(prev.Name)
+			}
+			return true
+		}
 		id := fromWildNode(node)
 		info := m.info(id)
 		if info.name == "" {
@@ -67,6 +346,17 @@ func (m *matcher) fillValues(node ast.Node, values map[string]ast.Node) ast.Node
 	return top.Node
 }
 
+// assignOpToken returns the compound assignment operator token such as
+// token.ADD_ASSIGN for its string, like "+=".
+func assignOpToken(s string) token.Token {
+	for tok := token.ADD_ASSIGN; tok <= token.AND_NOT_ASSIGN; tok++ {
+		if tok.String() == s {
+			return tok
+		}
+	}
+	return token.ILLEGAL
+}
+
 func (m *matcher) substNode(oldNode, newNode ast.Node) {
 	parent := m.parentOf(oldNode)
 	m.setParentOf(newNode, parent)
@@ -77,16 +367,42 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 		*x = newNode.(*ast.Ident)
 	case *ast.Node:
 		*x = newNode
+	case *ast.Decl:
+		*x = newNode.(ast.Decl)
 	case *ast.Expr:
-		*x = newNode.(ast.Expr)
+		// nil if an optional node was missing
+		*x, _ = newNode.(ast.Expr)
+		if *x != nil && needsParens(parent, x, *x) {
+			paren := &ast.ParenExpr{X: *x}
+			m.setParentOf(paren, parent)
+			m.setParentOf(*x, paren)
+			*x = paren
+		}
 	case *ast.Stmt:
 		switch y := newNode.(type) {
+		case nil:
+			*x = nil
 		case ast.Expr:
 			stmt := &ast.ExprStmt{X: y}
 			m.setParentOf(stmt, parent)
 			*x = stmt
+		case *ast.GenDecl:
+			// e.g. "var $x $type($x) = $y" replacing "$x := $y"
+			stmt := &ast.DeclStmt{Decl: y}
+			m.setParentOf(stmt, parent)
+			*x = stmt
 		case ast.Stmt:
 			*x = y
+		case stmtList:
+			// e.g. an optional init statement matched by $*_
+			switch len(y) {
+			case 0:
+				*x = nil
+			case 1:
+				*x = y[0]
+			default:
+				panic(fmt.Sprintf("cannot replace stmt with %d stmts", len(y)))
+			}
 		default:
 			panic(fmt.Sprintf("cannot replace stmt with %T", y))
 		}
@@ -96,7 +412,8 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 		for i, expr := range *x {
 			if expr == oldList[0] {
 				first = (*x)[:i]
-				last = (*x)[i+len(oldList):]
+				// copied, as appending to first may overwrite it
+				last = append([]ast.Expr(nil), (*x)[i+len(oldList):]...)
 				break
 			}
 		}
@@ -115,7 +432,7 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 		for i, stmt := range *x {
 			if stmt == oldList[0] {
 				first = (*x)[:i]
-				last = (*x)[i+len(oldList):]
+				last = append([]ast.Stmt(nil), (*x)[i+len(oldList):]...)
 				break
 			}
 		}
@@ -124,6 +441,10 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 			stmt := &ast.ExprStmt{X: y}
 			m.setParentOf(stmt, parent)
 			*x = append(first, stmt)
+		case *ast.GenDecl:
+			stmt := &ast.DeclStmt{Decl: y}
+			m.setParentOf(stmt, parent)
+			*x = append(first, stmt)
 		case ast.Stmt:
 			*x = append(first, y)
 		case stmtList:
@@ -142,6 +463,42 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 	fixPositions(parent)
 }
 
+// needsParens reports whether expr must be wrapped in parentheses when placed
+// at ptr within parent, so that the operator precedence is kept. For example,
+// when replacing $x with "a || b" in "!$x".
+func needsParens(parent ast.Node, ptr *ast.Expr, expr ast.Expr) bool {
+	prec := 0
+	switch x := expr.(type) {
+	case *ast.BinaryExpr:
+		prec = x.Op.Precedence()
+	case *ast.UnaryExpr, *ast.StarExpr:
+		prec = token.UnaryPrec
+	default:
+		return false
+	}
+	switch x := parent.(type) {
+	case *ast.UnaryExpr:
+		return prec < token.UnaryPrec
+	case *ast.StarExpr:
+		return prec < token.UnaryPrec
+	case *ast.BinaryExpr:
+		outer := x.Op.Precedence()
+		// binary operators are left-associative
+		return prec < outer || (prec == outer && ptr == &x.Y)
+	case *ast.SelectorExpr:
+		return ptr == &x.X
+	case *ast.IndexExpr:
+		return ptr == &x.X
+	case *ast.SliceExpr:
+		return ptr == &x.X
+	case *ast.TypeAssertExpr:
+		return ptr == &x.X
+	case *ast.CallExpr:
+		return ptr == &x.Fun
+	}
+	return false
+}
+
 func (m *matcher) parentOf(node ast.Node) ast.Node {
 	list, ok := node.(nodeList)
 	if ok {
@@ -150,6 +507,26 @@ func (m *matcher) parentOf(node ast.Node) ast.Node {
 	return m.parents[node]
 }
 
+// walkUp calls fn with node and its parent, and then with each of its
+// ancestors in turn, until fn returns false or the root is reached. The parent
+// of the root is nil.
+func (m *matcher) walkUp(node ast.Node, fn func(node, parent ast.Node) bool) {
+	for node != nil {
+		parent := m.parentOf(node)
+		if _, ok := node.(nodeList); ok {
+			if _, ok := parent.(nodeList); ok {
+				// the root of a source without a file, which
+				// is the parent of its own elements
+				parent = nil
+			}
+		}
+		if !fn(node, parent) {
+			return
+		}
+		node = parent
+	}
+}
+
 func (m *matcher) setParentOf(node, parent ast.Node) {
 	list, ok := node.(nodeList)
 	if ok {
@@ -189,6 +566,14 @@ func (m *matcher) nodePtr(node ast.Node) interface{} {
 			if fld.Interface() == node {
 				return fld.Addr().Interface()
 			}
+		case reflect.Ptr:
+			// e.g. a wildcard naming a func declaration in a
+			// replacement; other identifiers like func names or
+			// selectors are never substituted
+			if id, ok := node.(*ast.Ident); ok && isWildName(id.Name) &&
+				fld.Interface() == id {
+				return fld.Addr().Interface()
+			}
 		}
 	}
 	return nil
@@ -211,6 +596,10 @@ var posType = reflect.TypeOf(token.NoPos)
 
 func scrubPositions(node ast.Node) {
 	inspect(node, func(node ast.Node) bool {
+		ellipsis := token.NoPos
+		if call, ok := node.(*ast.CallExpr); ok {
+			ellipsis = call.Ellipsis
+		}
 		v := reflect.ValueOf(node)
 		if v.Kind() != reflect.Ptr {
 			return true
@@ -225,6 +614,11 @@ func scrubPositions(node ast.Node) {
 				fld.SetInt(0)
 			}
 		}
+		if call, ok := node.(*ast.CallExpr); ok && ellipsis.IsValid() {
+			// whether a call has a trailing "..." depends on
+			// this position being valid, so keep it
+			call.Ellipsis = ellipsis
+		}
 		return true
 	})
 }
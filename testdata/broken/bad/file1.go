@@ -0,0 +1,5 @@
+package bad
+
+var _ = "bad"
+
+var _ int = undefinedName
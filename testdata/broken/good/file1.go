@@ -0,0 +1,3 @@
+package good
+
+var _ = "good"
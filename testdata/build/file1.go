@@ -0,0 +1,5 @@
+//go:build !plan9
+
+package build
+
+var _ = "file1"
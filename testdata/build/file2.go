@@ -0,0 +1,5 @@
+//go:build gc || gccgo
+
+package build
+
+var _ = "file2"
@@ -0,0 +1,12 @@
+package directives
+
+//go:generate stringer -type=T
+//go:generate go run gen.go
+
+// go:generate is not a directive with a space
+//go:generated is not one either
+
+type T int
+
+//go:noinline
+func f() {}
@@ -0,0 +1,15 @@
+package escapes
+
+type T struct{ n int }
+
+func New() *T { return &T{n: 1} }
+
+func Local() int {
+	t := &T{n: 2}
+	return t.n
+}
+
+func Moved() *int {
+	x := 3
+	return &x
+}
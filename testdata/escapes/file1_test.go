@@ -0,0 +1,9 @@
+package escapes
+
+import "testing"
+
+var sink *T
+
+func TestNew(t *testing.T) {
+	sink = &T{n: 4}
+}
@@ -0,0 +1,3 @@
+package gen
+
+var _ = "file1"
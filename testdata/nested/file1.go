@@ -0,0 +1,3 @@
+package nested
+
+func f(x int) int { return f(f(1)) }
@@ -0,0 +1,6 @@
+package prints
+
+func f() {
+	print(1)
+	println(2)
+}
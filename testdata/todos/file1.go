@@ -0,0 +1,22 @@
+package todos
+
+func none() {}
+
+// TODO: rename
+func one() {}
+
+// FIXME: too slow
+func two() {
+	// TODO: cache this
+	work()
+}
+
+func three() {
+	// TODO: one
+	// TODO: two
+	_ = func() {
+		work() // FIXME: three
+	}
+}
+
+func work() {}
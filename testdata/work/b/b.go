@@ -0,0 +1,3 @@
+package b
+
+var _ = "b"
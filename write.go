@@ -4,9 +4,11 @@
 package main
 
 import (
+	"bytes"
 	"go/ast"
 	"go/printer"
 	"os"
+	"sort"
 )
 
 func (m *matcher) cmdWrite(cmd exprCmd, subs []submatch) []submatch {
@@ -32,20 +34,51 @@ func (m *matcher) cmdWrite(cmd exprCmd, subs []submatch) []submatch {
 		// pass it on, to print to stdout
 		next = append(next, submatch{node: root})
 	}
-	for file, path := range filePaths {
-		f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
-		if err != nil {
-			// TODO: return errors instead
-			panic(err)
-		}
-		if err := printConfig.Fprint(f, m.fset, file); err != nil {
-			// TODO: return errors instead
-			panic(err)
+	// write the files in a stable order, so that errors are too
+	files := make([]*ast.File, 0, len(filePaths))
+	for file := range filePaths {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return filePaths[files[i]] < filePaths[files[j]]
+	})
+	for _, file := range files {
+		if err := m.writeFile(filePaths[file], file); err != nil {
+			m.writeErrs = append(m.writeErrs, err)
 		}
 	}
 	return next
 }
 
+func (m *matcher) writeFile(path string, file *ast.File) error {
+	src, err := m.formatFile(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(src); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// formatFile returns the source of file as -w would write it to disk.
+func (m *matcher) formatFile(file *ast.File) ([]byte, error) {
+	config := printConfig
+	if m.tabWidth > 0 {
+		config = printer.Config{Mode: printer.UseSpaces, Tabwidth: m.tabWidth}
+	}
+	var buf bytes.Buffer
+	if err := config.Fprint(&buf, m.fset, file); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 var printConfig = printer.Config{
 	Mode:     printer.UseSpaces | printer.TabIndent,
 	Tabwidth: 8,
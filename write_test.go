@@ -6,7 +6,9 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
 	"go/build"
+	"go/token"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -92,3 +94,118 @@ func f3() {
 		}
 	}
 }
+
+func TestWriteErrors(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only files")
+	}
+	dir, err := ioutil.TempDir("", "gogrep-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var paths []string
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("f%02d.go", i))
+		src := fmt.Sprintf("package p\n\nfunc f%d() { println() }\n", i)
+		if err := ioutil.WriteFile(path, []byte(src), 0444); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	// the errors must follow the order of the files, not the arguments
+	args := []string{"-x", "println()", "-s", "print()", "-w", paths[1], paths[0]}
+	m := matcher{ctx: &build.Default, out: ioutil.Discard}
+	err = m.fromArgs(".", args)
+	want := fmt.Sprintf("open %s: permission denied\nopen %s: permission denied",
+		paths[0], paths[1])
+	if err == nil || err.Error() != want {
+		t.Fatalf("wanted error:\n%s\ngot:\n%v", want, err)
+	}
+}
+
+func TestWriteTabWidth(t *testing.T) {
+	orig := "package p\n\nfunc f() {\n\tif true {\n\t\tprintln(1)\n\t}\n}\n"
+	dir, err := ioutil.TempDir("", "gogrep-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "f.go")
+	for _, tc := range []struct {
+		width string
+		want  string
+	}{
+		{"2", "package p\n\nfunc f() {\n  if true {\n    print(1)\n  }\n}\n"},
+		{"4", "package p\n\nfunc f() {\n    if true {\n        print(1)\n    }\n}\n"},
+	} {
+		if err := ioutil.WriteFile(path, []byte(orig), 0644); err != nil {
+			t.Fatal(err)
+		}
+		args := []string{"-tabwidth", tc.width, "-x", "println($x)", "-s", "print($x)", "-w", path}
+		m := matcher{ctx: &build.Default, out: ioutil.Discard}
+		if err := m.fromArgs(".", args); err != nil {
+			t.Fatalf("didn't want error, but got %q", err)
+		}
+		gotBs, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(gotBs); got != tc.want {
+			t.Fatalf("-tabwidth %s mismatch:\nwant:\n%sgot:\n%s", tc.width, tc.want, got)
+		}
+	}
+}
+
+func TestFormatFile(t *testing.T) {
+	orig := "package p\n\nfunc foo() {}\n\nfunc bar() {}\n\nfunc f() {\n\tfoo()\n}\n"
+	dir, err := ioutil.TempDir("", "gogrep-write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "f.go")
+	if err := ioutil.WriteFile(path, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, width := range []string{"0", "4"} {
+		args := []string{"-tabwidth", width, "-x", "foo()", "-s", "bar()"}
+
+		// the bytes the file would be written with
+		m := matcher{ctx: &build.Default, out: ioutil.Discard, fset: token.NewFileSet()}
+		cmds, _, err := m.parseCmds(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkgs, err := m.load(".", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := pkgs[0].Syntax[0]
+		m.Info, m.pkg = pkgs[0].TypesInfo, pkgs[0].Types
+		m.submatchesOf(cmds, []ast.Node{file})
+		want, err := m.formatFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the bytes the file is written with
+		m = matcher{ctx: &build.Default, out: ioutil.Discard}
+		if err := m.fromArgs(".", append(args, "-w", path)); err != nil {
+			t.Fatalf("didn't want error, but got %q", err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(want, []byte("bar()\n")) {
+			t.Fatalf("-tabwidth %s: substitution missing:\n%s", width, want)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("-tabwidth %s mismatch:\nformatFile:\n%s-w:\n%s", width, want, got)
+		}
+		if err := ioutil.WriteFile(path, []byte(orig), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}